@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	AlertDown = "site.down"
+	AlertUp   = "site.up"
+	AlertCert = "site.cert_expiring"
+	AlertTest = "test"
+	// AlertFlapping and AlertSettled summarize a flapping site instead of
+	// alerting each status change
+	AlertFlapping = "site.flapping"
+	AlertSettled  = "site.settled"
+)
+
+// Alert is something worth telling a user about, each channel kind formats
+// it its own way
+type Alert struct {
+	Event      string
+	Site       Site
+	Ping       Ping
+	Transition Transition
+	Incident   Incident
+	AckUrl     string // acknowledges the incident, for down alerts
+	Escalation int    // how many escalation steps were notified before this one
+	Flaps      int    // status changes within Window, for flapping alerts
+	Window     time.Duration
+}
+
+// Headline and Detail are the plain text used by chat channels
+func (a Alert) Headline() string {
+	switch a.Event {
+	case AlertDown:
+		return fmt.Sprintf("%s is down", a.Site.Title())
+	case AlertUp:
+		return fmt.Sprintf("%s is back up", a.Site.Title())
+	case AlertCert:
+		if a.Site.CertDaysLeft() < 0 {
+			return fmt.Sprintf("%s's certificate has expired", a.Site.Title())
+		}
+		return fmt.Sprintf("%s's certificate expires in %d days", a.Site.Title(), a.Site.CertDaysLeft())
+	case AlertFlapping:
+		return fmt.Sprintf("%s is flapping", a.Site.Title())
+	case AlertSettled:
+		return fmt.Sprintf("%s has settled", a.Site.Title())
+	case AlertTest:
+		return "Test notification from all your uptime"
+	}
+	return a.Site.Title()
+}
+
+func (a Alert) Detail() string {
+	switch a.Event {
+	case AlertDown:
+		if a.Escalation > 0 {
+			return "not acknowledged yet, " + a.Incident.Reason()
+		}
+		return a.Incident.Reason()
+	case AlertUp:
+		return fmt.Sprintf("after %v of downtime, it went down with %s", a.Incident.Duration(), a.Incident.Reason())
+	case AlertCert:
+		return fmt.Sprintf("issued by %s, expires %s", a.Site.CertIssuer.String, time.Unix(a.Site.CertExpiresAt.Int64, 0).UTC().Format(time.RFC1123))
+	case AlertFlapping:
+		return fmt.Sprintf("changed status %d times in %v, alerts are paused until it settles", a.Flaps, a.Window)
+	case AlertSettled:
+		return fmt.Sprintf("it has been %s for %v, alerts are back on", a.Site.Status.String, a.Window)
+	case AlertTest:
+		return "alerts for your sites will arrive here"
+	}
+	return ""
+}
+
+// At is when the alert happened
+func (a Alert) At() int64 {
+	switch a.Event {
+	case AlertDown:
+		return a.Incident.StartedAt
+	case AlertUp:
+		return a.Incident.ResolvedAt.Int64
+	}
+	return time.Now().Unix()
+}
+
+// testAlert is sent by the "send test notification" button
+func testAlert(config Config) Alert {
+	return Alert{
+		Event: AlertTest,
+		Site:  Site{Name: nullify("all your uptime"), Url: config.BaseUrl + "/"},
+	}
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,12 +25,18 @@ type Middleware func(http.HandlerFunc) http.HandlerFunc
 
 type View struct {
 	SuccessFlash  string
+	ErrorFlash    string
 	CurrentUserId int64
 	CsrfToken     string
+	BaseUrl       string // heartbeat urls are shown in full
 	Home
 	NewSite
 	Profile
 	Login
+	Channels
+	Escalations
+	Acknowledge
+	Maintenance
 }
 
 type Login struct {
@@ -33,15 +45,59 @@ type Login struct {
 }
 
 type NewSite struct {
-	Url          string
-	BlankUrl     bool
-	DuplicateUrl bool
-	Name         string
+	Id                     int64 // the site being edited
+	Url                    string
+	BlankUrl               bool
+	UrlError               string
+	DuplicateUrl           bool
+	Name                   string
+	CheckType              string
+	InvalidCheckType       bool
+	CheckInterval          string
+	InvalidCheckInterval   bool
+	Timeout                string
+	InvalidTimeout         bool
+	ConfirmFailures        string
+	InvalidConfirmFailures bool
+	RetryDelay             string
+	InvalidRetryDelay      bool
+	Method                 string
+	InvalidMethod          bool
+	Headers                string
+	HeadersError           string
+	Body                   string
+	InvalidBody            bool
+	ExpectedStatus         string
+	ExpectedStatusError    string
+	BodyContains           string
+	BodyNotContains        string
+	BodyRegex              string
+	BodyRegexError         string
+	AssertsWithoutBody     bool
+	InvalidTcpAsserts      bool
+	JsonAssertions         string
+	JsonAssertionsError    string
+	DnsResolver            string
+	DnsResolverError       string
+	DnsRecordType          string
+	InvalidDnsRecordType   bool
+	DnsExpected            string
+	Grace                  string
+	InvalidGrace           bool
+}
+
+func (n NewSite) Valid() bool {
+	return !n.BlankUrl && n.UrlError == "" && !n.DuplicateUrl && !n.InvalidCheckInterval && !n.InvalidTimeout &&
+		!n.InvalidConfirmFailures && !n.InvalidRetryDelay && !n.InvalidMethod && n.HeadersError == "" &&
+		!n.InvalidBody && n.ExpectedStatusError == "" && n.BodyRegexError == "" && !n.AssertsWithoutBody &&
+		!n.InvalidTcpAsserts && !n.InvalidCheckType && n.JsonAssertionsError == "" && n.DnsResolverError == "" &&
+		!n.InvalidDnsRecordType && !n.InvalidGrace
 }
 
 type Home struct {
-	Passcode string
-	Sites    []Site
+	Passcode    string
+	Sites       []Site
+	Maintenance []MaintenanceWindow // active and upcoming
 }
 
 type Profile struct {
@@ -51,6 +107,7 @@ type Profile struct {
 
 type App struct {
 	model       Model
+	worker      Worker
 	logger      Logger
 	mux         *http.ServeMux
 	templates   *template.Template
@@ -69,16 +126,55 @@ func templateMap() map[string]*template.Template {
 		log.Fatal(err)
 	}
 
+	// partials start with an underscore and are parsed into every view
+	var partials []string
 	for _, f := range files {
-		templates[f.Name()] = template.Must(template.ParseFiles("views/layout.tmpl", "views/"+f.Name()))
+		if strings.HasPrefix(f.Name(), "_") {
+			partials = append(partials, "views/"+f.Name())
+		}
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "_") {
+			continue
+		}
+		names := append([]string{"views/layout.tmpl", "views/" + f.Name()}, partials...)
+		templates[f.Name()] = template.Must(template.New("layout.tmpl").Funcs(templateFuncs).ParseFiles(names...))
 	}
 
 	return templates
 }
 
-func NewApp(logger Logger, model Model) (*App, error) {
+var templateFuncs = template.FuncMap{
+	"dnsRecordTypes": func() []string {
+		return dnsRecordTypes
+	},
+	"notifierKinds": NotifierKinds,
+	"notifierLabel": func(kind string) string {
+		notifier, err := findNotifier(kind)
+		if err != nil {
+			return kind
+		}
+		return notifier.Label()
+	},
+	"datetime": func(unix int64) string {
+		return time.Unix(unix, 0).UTC().Format("Jan 2 2006 15:04 UTC")
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+	"percent": func(f sql.NullFloat64) string {
+		if !f.Valid {
+			return "N/A"
+		}
+		// rounding down keeps 99.999% from showing as a perfect 100%
+		return fmt.Sprintf("%.2f%%", math.Floor(f.Float64*100)/100)
+	},
+}
+
+func NewApp(logger Logger, model Model, worker Worker) (*App, error) {
 	app := &App{
 		model:       model,
+		worker:      worker,
 		logger:      logger,
 		mux:         http.NewServeMux(),
 		templateMap: templateMap(),
@@ -96,10 +192,31 @@ func (app *App) addRoutes() {
 	app.post("/logout", app.private(app.logout))
 	app.get("/new-site", app.private(app.newSite))
 	app.post("/create-site", app.private(app.createSite))
+	app.get("/edit-site", app.private(app.editSite))
+	app.post("/update-site", app.private(app.updateSite))
 	app.post("/delete-site", app.private(app.deleteSite))
+	app.post("/pause-site", app.private(app.pauseSite))
+	app.post("/resume-site", app.private(app.resumeSite))
 	app.get("/profile", app.private(app.profile))
 	app.post("/update-profile", app.private(app.updateProfile))
 	app.post("/delete-account", app.private(app.deleteAccount))
+	app.get("/channels", app.private(app.channels))
+	app.post("/create-channel", app.private(app.createChannel))
+	app.post("/test-channel", app.private(app.testChannel))
+	app.post("/route-channel", app.private(app.routeChannel))
+	app.post("/delete-channel", app.private(app.deleteChannel))
+	app.get("/escalations", app.private(app.escalations))
+	app.post("/create-escalation", app.private(app.createEscalation))
+	app.post("/assign-escalation", app.private(app.assignEscalation))
+	app.post("/delete-escalation", app.private(app.deleteEscalation))
+	app.get("/maintenance", app.private(app.maintenance))
+	app.post("/create-maintenance", app.private(app.createMaintenance))
+	app.post("/delete-maintenance", app.private(app.deleteMaintenance))
+	app.post("/acknowledge-incident", app.private(app.acknowledgeIncident))
+	app.get("/acknowledge", app.acknowledge)
+	app.post("/acknowledge-token", app.acknowledgeToken)
+
+	app.mux.HandleFunc(heartbeatPrefix, app.heartbeat)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	app.mux.Handle("/static/", http.StripPrefix("/static", fileServer))
@@ -149,32 +266,272 @@ func (app *App) createSession(w http.ResponseWriter, r *http.Request) {
 func (app *App) newSite(w http.ResponseWriter, r *http.Request) {
 	view := View{
 		NewSite: NewSite{
-			Url:      r.FormValue("url"),
-			Name:     r.FormValue("name"),
-			BlankUrl: false,
+			Url:             r.FormValue("url"),
+			Name:            r.FormValue("name"),
+			BlankUrl:        false,
+			CheckType:       CheckHttp,
+			DnsRecordType:   "A",
+			CheckInterval:   "300",
+			Timeout:         "10",
+			ConfirmFailures: "2",
+			RetryDelay:      "10",
+			Method:          http.MethodGet,
+			ExpectedStatus:  "200-399",
+			Grace:           "300",
 		},
 	}
 	app.render(w, r, "new-site", view)
 }
 
 func (app *App) createSite(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
-	url := r.FormValue("url")
-	userId := app.currentUserId(r) // TODO: context?
-	_, err := app.model.CreateSite(userId, name, url)
-	if err != nil {
+	form, site := siteForm(r, app.worker.config)
+	site.UserId = app.currentUserId(r) // TODO: context?
+	if form.Valid() {
+		_, err := app.model.CreateSite(site)
+		if err == nil {
+			redirect(w, r, "/")
+			return
+		}
 		var sqliteErr sqlite3.Error
-		view := View{
-			NewSite: NewSite{
-				Url:          url,
-				Name:         name,
-				BlankUrl:     len(strings.TrimSpace(url)) == 0,
-				DuplicateUrl: errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique,
-			},
+		form.DuplicateUrl = errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+		if !form.DuplicateUrl {
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
 		}
-		app.render(w, r, "new-site", view)
 	}
-	redirect(w, r, "/")
+	app.render(w, r, "new-site", View{NewSite: form})
+}
+
+func (app *App) editSite(w http.ResponseWriter, r *http.Request) {
+	site := app.findSite(r)
+	if site == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	app.render(w, r, "edit-site", View{NewSite: siteFormValues(*site)})
+}
+
+// updateSite changes a site in place so its pings, incidents and uptime stay
+// with it
+func (app *App) updateSite(w http.ResponseWriter, r *http.Request) {
+	existing := app.findSite(r)
+	if existing == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	form, site := siteForm(r, app.worker.config)
+	form.Id = existing.Id
+	site.Id = existing.Id
+	site.UserId = existing.UserId
+	if site.CheckType == CheckHeartbeat && existing.CheckType == CheckHeartbeat {
+		// jobs keep pinging the url they were given
+		site.Url = existing.Url
+	}
+	if form.Valid() {
+		_, err := app.model.UpdateSite(site)
+		if err == nil {
+			SetFlash(w, "success", []byte("Site saved"))
+			redirect(w, r, "/")
+			return
+		}
+		var sqliteErr sqlite3.Error
+		form.DuplicateUrl = errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+		if !form.DuplicateUrl {
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	app.render(w, r, "edit-site", View{NewSite: form})
+}
+
+// findSite returns the current user's site with the id in the request, or
+// nil
+func (app *App) findSite(r *http.Request) *Site {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	site, err := app.model.FindSite(id)
+	haltOn(err)
+	if site == nil || site.UserId != app.currentUserId(r) {
+		return nil
+	}
+	return site
+}
+
+// siteFormValues fills the site form from a saved site
+func siteFormValues(site Site) NewSite {
+	form := NewSite{
+		Id:              site.Id,
+		Url:             site.Url,
+		Name:            site.Name.String,
+		CheckType:       site.CheckType,
+		CheckInterval:   strconv.Itoa(site.CheckInterval),
+		Timeout:         strconv.Itoa(site.Timeout),
+		ConfirmFailures: strconv.Itoa(site.ConfirmFailures),
+		RetryDelay:      strconv.Itoa(site.RetryDelay),
+		Method:          site.Method,
+		Headers:         site.Headers,
+		Body:            site.Body,
+		ExpectedStatus:  site.ExpectedStatus,
+		BodyContains:    site.BodyContains,
+		BodyNotContains: site.BodyNotContains,
+		BodyRegex:       site.BodyRegex,
+		JsonAssertions:  site.JsonAssertions,
+		DnsResolver:     site.DnsResolver,
+		DnsRecordType:   site.DnsRecordType,
+		DnsExpected:     site.DnsExpected,
+		Grace:           strconv.Itoa(site.Grace),
+	}
+	if site.CheckType == CheckHeartbeat {
+		// the ping url isn't edited, it is on the dashboard
+		form.Url = ""
+	}
+	return form
+}
+
+// siteForm reads a site from the posted form, the returned form holds the
+// submitted values and any validation errors
+func siteForm(r *http.Request, config Config) (NewSite, Site) {
+	form := NewSite{
+		Url:             r.FormValue("url"),
+		Name:            r.FormValue("name"),
+		CheckType:       r.FormValue("check_type"),
+		CheckInterval:   r.FormValue("check_interval"),
+		Timeout:         r.FormValue("timeout"),
+		ConfirmFailures: r.FormValue("confirm_failures"),
+		RetryDelay:      r.FormValue("retry_delay"),
+		Method:          r.FormValue("method"),
+		Headers:         r.FormValue("headers"),
+		Body:            r.FormValue("body"),
+		ExpectedStatus:  r.FormValue("expected_status"),
+		BodyContains:    r.FormValue("body_contains"),
+		BodyNotContains: r.FormValue("body_not_contains"),
+		BodyRegex:       r.FormValue("body_regex"),
+		JsonAssertions:  r.FormValue("json_assertions"),
+		DnsResolver:     r.FormValue("dns_resolver"),
+		DnsRecordType:   r.FormValue("dns_record_type"),
+		DnsExpected:     r.FormValue("dns_expected"),
+		Grace:           r.FormValue("grace"),
+	}
+	heartbeat := form.CheckType == CheckHeartbeat
+	// heartbeat sites get a url to be pinged at instead of one to check
+	form.BlankUrl = len(strings.TrimSpace(form.Url)) == 0 && !heartbeat
+	form.InvalidCheckType = form.CheckType != CheckHttp && form.CheckType != CheckJson &&
+		form.CheckType != CheckTcp && form.CheckType != CheckDns && !heartbeat
+	address := strings.TrimSpace(form.Url)
+	if heartbeat {
+		address = newHeartbeatUrl()
+	}
+	if (form.CheckType == CheckHttp || form.CheckType == CheckJson) && !form.BlankUrl {
+		normalized, err := httpUrl(address)
+		if err != nil {
+			form.UrlError = err.Error()
+		} else {
+			address = normalized
+		}
+	}
+	if form.CheckType == CheckTcp && !form.BlankUrl {
+		host, err := tcpAddress(address)
+		if err != nil {
+			form.UrlError = err.Error()
+		} else {
+			address = "tcp://" + host
+		}
+	}
+	if form.UrlError == "" && !form.BlankUrl && !config.AllowPrivateTargets &&
+		(form.CheckType == CheckHttp || form.CheckType == CheckJson || form.CheckType == CheckTcp) {
+		u, _ := url.Parse(address)
+		err := publicHost(u.Hostname())
+		if err != nil {
+			form.UrlError = err.Error()
+		}
+	}
+	if form.CheckType == CheckDns && !form.BlankUrl {
+		name, err := dnsName(address)
+		if err != nil {
+			form.UrlError = err.Error()
+		}
+		address = name
+	}
+	form.DnsResolver = strings.TrimSpace(form.DnsResolver)
+	if form.DnsResolver != "" {
+		host, _, err := net.SplitHostPort(form.DnsResolver)
+		if err != nil {
+			form.DnsResolverError = "Resolvers look like address:port"
+		} else if !config.AllowPrivateTargets {
+			err = publicHost(host)
+			if err != nil {
+				form.DnsResolverError = err.Error()
+			}
+		}
+	}
+	form.InvalidDnsRecordType = form.CheckType == CheckDns && !contains(dnsRecordTypes, form.DnsRecordType)
+
+	interval, err := strconv.Atoi(strings.TrimSpace(form.CheckInterval))
+	maxInterval := MaxCheckInterval
+	if heartbeat {
+		maxInterval = MaxHeartbeatInterval
+	}
+	form.InvalidCheckInterval = err != nil || interval < MinCheckInterval || interval > maxInterval
+	timeout, err := strconv.Atoi(strings.TrimSpace(form.Timeout))
+	form.InvalidTimeout = err != nil || timeout < 1 || timeout > MaxTimeout || timeout >= interval
+	confirmFailures, err := strconv.Atoi(strings.TrimSpace(form.ConfirmFailures))
+	form.InvalidConfirmFailures = err != nil || confirmFailures < 1 || confirmFailures > MaxConfirmations
+	retryDelay, err := strconv.Atoi(strings.TrimSpace(form.RetryDelay))
+	form.InvalidRetryDelay = err != nil || retryDelay < 1 || retryDelay > MaxRetryDelay || retryDelay >= interval
+	if heartbeat {
+		// a missed heartbeat can't be retried, it is down straight away
+		confirmFailures = 1
+		form.InvalidConfirmFailures = false
+	}
+	grace, err := strconv.Atoi(strings.TrimSpace(form.Grace))
+	form.InvalidGrace = heartbeat && (err != nil || grace < 0 || grace > MaxGrace)
+	form.InvalidMethod = form.Method != http.MethodGet && form.Method != http.MethodHead && form.Method != http.MethodPost
+	_, err = ParseHeaders(form.Headers)
+	if err != nil {
+		form.HeadersError = err.Error()
+	}
+	// tcp checks send the body as their payload
+	form.InvalidBody = form.Body != "" && form.Method != http.MethodPost && form.CheckType != CheckTcp
+	// tcp checks only wait for a banner, they can't tell when a response is over
+	form.InvalidTcpAsserts = form.CheckType == CheckTcp && (form.BodyNotContains != "" || form.BodyRegex != "")
+	expected, err := ParseStatusRanges(form.ExpectedStatus)
+	if err != nil {
+		form.ExpectedStatusError = err.Error()
+	}
+	_, err = regexp.Compile(form.BodyRegex)
+	if err != nil {
+		form.BodyRegexError = err.Error()
+	}
+	_, err = ParseJsonAssertions(form.JsonAssertions)
+	if err != nil {
+		form.JsonAssertionsError = err.Error()
+	}
+
+	site := Site{
+		Name:            nullify(form.Name),
+		Url:             address,
+		CheckType:       form.CheckType,
+		CheckInterval:   interval,
+		Timeout:         timeout,
+		ConfirmFailures: confirmFailures,
+		RetryDelay:      retryDelay,
+		Method:          form.Method,
+		Headers:         strings.TrimSpace(form.Headers),
+		Body:            form.Body,
+		ExpectedStatus:  expected.String(),
+		BodyContains:    form.BodyContains,
+		BodyNotContains: form.BodyNotContains,
+		BodyRegex:       form.BodyRegex,
+		JsonAssertions:  strings.TrimSpace(form.JsonAssertions),
+		DnsResolver:     form.DnsResolver,
+		DnsRecordType:   form.DnsRecordType,
+		DnsExpected:     strings.TrimSpace(form.DnsExpected),
+		Grace:           grace,
+	}
+	form.AssertsWithoutBody = site.Asserts() && site.Method == http.MethodHead && site.CheckType != CheckTcp
+	return form, site
 }
 
 func (app *App) deleteSite(w http.ResponseWriter, r *http.Request) {
@@ -206,12 +563,28 @@ func (app *App) home(w http.ResponseWriter, r *http.Request) {
 	flash, err := GetFlash(w, r, "passcode")
 	successFlash, err := GetFlash(w, r, "success")
 	haltOn(err)
-	sites := app.model.ListSites(app.currentUserId(r))
+	errorFlash, err := GetFlash(w, r, "error")
+	haltOn(err)
+	userId := app.currentUserId(r)
+	sites := app.model.ListSites(userId)
+	windows, err := app.model.ListMaintenance(userId)
+	haltOn(err)
+	now := time.Now()
+	upcoming := upcomingMaintenance(windows, now)
+	for i := range sites {
+		for _, w := range upcoming {
+			if w.Covers(sites[i]) && w.Ongoing && w.NextEnd > sites[i].MaintenanceUntil.Int64 {
+				sites[i].MaintenanceUntil = sql.NullInt64{Int64: w.NextEnd, Valid: true}
+			}
+		}
+	}
 	view := View{
 		SuccessFlash: string(successFlash),
+		ErrorFlash:   string(errorFlash),
 		Home: Home{
-			Passcode: string(flash),
-			Sites:    sites,
+			Passcode:    string(flash),
+			Sites:       sites,
+			Maintenance: upcoming,
 		},
 	}
 	app.render(w, r, "index", view)
@@ -307,6 +680,7 @@ func (app *App) currentUserId(r *http.Request) int64 {
 func (app *App) render(w http.ResponseWriter, r *http.Request, name string, view View) {
 	view.CurrentUserId = app.currentUserId(r)
 	view.CsrfToken = app.setCsrfToken(w, r)
+	view.BaseUrl = baseUrl(r)
 	app.templateMap[name+".tmpl"].ExecuteTemplate(w, "layout.tmpl", view)
 }
 
@@ -392,6 +766,15 @@ func use(h http.HandlerFunc, middleware ...Middleware) http.HandlerFunc {
 	return h
 }
 
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func haltOn(err error) {
 	if err != nil {
 		log.Fatal(err)
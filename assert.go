@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// maxBodyRead is how much of a response body assertions look at, a check
+// shouldn't download a whole file to find a keyword
+const maxBodyRead = 1 << 20
+
+// Asserts reports whether the site has assertions on the response body
+func (s Site) Asserts() bool {
+	return s.CheckType == CheckJson || s.BodyContains != "" || s.BodyNotContains != "" || s.BodyRegex != ""
+}
+
+// assertBody checks a response body against the site's assertions and
+// describes the first one that fails, or returns "" when they all pass
+func assertBody(site Site, body []byte) string {
+	if site.BodyContains != "" && !bytes.Contains(body, []byte(site.BodyContains)) {
+		return fmt.Sprintf("body does not contain %q", site.BodyContains)
+	}
+	if site.BodyNotContains != "" && bytes.Contains(body, []byte(site.BodyNotContains)) {
+		return fmt.Sprintf("body contains %q", site.BodyNotContains)
+	}
+	if site.BodyRegex != "" {
+		re, err := regexp.Compile(site.BodyRegex)
+		if err != nil {
+			return fmt.Sprintf("invalid regex %q: %v", site.BodyRegex, err)
+		}
+		if !re.Match(body) {
+			return fmt.Sprintf("body does not match /%s/", site.BodyRegex)
+		}
+	}
+	if site.CheckType == CheckJson {
+		return assertJson(site, body)
+	}
+	return ""
+}
+
+// assertJson parses the body as JSON and checks it against the site's json
+// assertions
+func assertJson(site Site, body []byte) string {
+	assertions, err := ParseJsonAssertions(site.JsonAssertions)
+	if err != nil {
+		return err.Error()
+	}
+	var doc interface{}
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return fmt.Sprintf("body is not JSON: %v", err)
+	}
+	for _, assertion := range assertions {
+		if failed := assertion.Check(doc); failed != "" {
+			return failed
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Cert is what an https check saw of a site's leaf certificate
+type Cert struct {
+	ExpiresAt int64
+	Issuer    string
+	Sans      string // comma separated
+	Valid     bool   // the chain verifies for the site's host
+	Error     sql.NullString
+	CheckedAt int64
+}
+
+// certCapture verifies server certificates itself so it can record them
+// even when they are invalid, the handshake still fails for invalid ones
+type certCapture struct {
+	cert *Cert
+}
+
+func (c *certCapture) tlsConfig() *tls.Config {
+	return &tls.Config{
+		// verification happens in verify, which also records the certificate
+		InsecureSkipVerify: true,
+		VerifyConnection:   c.verify,
+	}
+}
+
+func (c *certCapture) verify(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Intermediates: intermediates,
+	})
+
+	// redirects can connect to other hosts, the first certificate is the site's
+	if c.cert == nil {
+		c.cert = &Cert{
+			ExpiresAt: leaf.NotAfter.Unix(),
+			Issuer:    leaf.Issuer.String(),
+			Sans:      strings.Join(sans(leaf), ", "),
+			Valid:     err == nil,
+			CheckedAt: time.Now().Unix(),
+		}
+		if err != nil {
+			c.cert.Error = nullify(err.Error())
+		}
+	}
+	return err
+}
+
+func sans(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// CertDaysLeft is the number of whole days until the certificate expires,
+// negative once it has
+func (s Site) CertDaysLeft() int {
+	left := time.Until(time.Unix(s.CertExpiresAt.Int64, 0))
+	if left < 0 {
+		return int(left/(24*time.Hour)) - 1
+	}
+	return int(left / (24 * time.Hour))
+}
+
+// CertWarning reports whether the site's certificate needs attention
+func (s Site) CertWarning() bool {
+	return s.CertWarnedDays.Valid || (s.CertValid.Valid && !s.CertValid.Bool)
+}
+
+// certWarnLevel returns the most urgent threshold the days left have
+// crossed, 0 for an expired certificate, or nothing when there is time left
+func certWarnLevel(daysLeft int, thresholds []int) sql.NullInt64 {
+	if daysLeft < 0 {
+		return sql.NullInt64{Int64: 0, Valid: true}
+	}
+	level := sql.NullInt64{}
+	for _, threshold := range thresholds {
+		if daysLeft <= threshold && (!level.Valid || int64(threshold) < level.Int64) {
+			level = sql.NullInt64{Int64: int64(threshold), Valid: true}
+		}
+	}
+	return level
+}
+
+// RecordCert stores the certificate seen by a check on its site along with
+// the warning threshold it has crossed, and returns the previous threshold
+func (m *Model) RecordCert(siteId int64, cert Cert, level sql.NullInt64) (sql.NullInt64, error) {
+	var previous sql.NullInt64
+	err := scan(m.db.QueryRow(`select cert_warned_days from sites where id = $1`, siteId), &previous)
+	if err != nil {
+		return previous, err
+	}
+	_, err = m.db.Exec(
+		`
+		update sites
+		set
+			cert_expires_at = $1,
+			cert_issuer = $2,
+			cert_sans = $3,
+			cert_valid = $4,
+			cert_error = $5,
+			cert_checked_at = $6,
+			cert_warned_days = $7
+		where id = $8
+		`,
+		cert.ExpiresAt, cert.Issuer, cert.Sans, cert.Valid, cert.Error, cert.CheckedAt, level, siteId,
+	)
+	return previous, err
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Notification channels are where a user's alerts go besides their profile
+// email, each kind is a registered Notifier. A channel gets alerts for all
+// of its user's sites unless it is routed to some of them.
+
+type Channel struct {
+	Id        int64
+	UserId    int64
+	Kind      string
+	Name      sql.NullString
+	Url       string
+	Secret    string  // signs webhook payloads, the routing key for pagerduty
+	SiteIds   []int64 // the sites it is routed to, empty for all of them
+	UpdatedAt sql.NullInt64
+	CreatedAt int64
+}
+
+// Routes reports whether the channel was routed to the site, rather than
+// getting it as one of all sites
+func (c Channel) Routes(siteId int64) bool {
+	for _, id := range c.SiteIds {
+		if id == siteId {
+			return true
+		}
+	}
+	return false
+}
+
+// Title is how a channel is named in the delivery log
+func (c Channel) Title() string {
+	if c.Name.Valid {
+		return c.Name.String
+	}
+	return c.Url
+}
+
+type Channels struct {
+	Channels    []Channel
+	Deliveries  []Delivery
+	Sites       []Site
+	Kind        string
+	InvalidKind bool
+	Name        string
+	Url         string
+	RoutingKey  string
+	ConfigError string
+}
+
+func (app *App) channels(w http.ResponseWriter, r *http.Request) {
+	app.renderChannels(w, r, Channels{})
+}
+
+func (app *App) renderChannels(w http.ResponseWriter, r *http.Request, form Channels) {
+	userId := app.currentUserId(r)
+	channels, err := app.model.ListChannels(userId)
+	haltOn(err)
+	deliveries, err := app.model.ListDeliveries(userId, deliveryLogSize)
+	haltOn(err)
+	form.Channels = channels
+	form.Deliveries = deliveries
+	form.Sites = app.model.ListSites(userId)
+	successFlash, err := GetFlash(w, r, "success")
+	haltOn(err)
+	errorFlash, err := GetFlash(w, r, "error")
+	haltOn(err)
+	app.render(w, r, "channels", View{SuccessFlash: string(successFlash), ErrorFlash: string(errorFlash), Channels: form})
+}
+
+func (app *App) createChannel(w http.ResponseWriter, r *http.Request) {
+	form := Channels{
+		Kind: r.FormValue("kind"),
+		Name: r.FormValue("name"),
+		Url:  strings.TrimSpace(r.FormValue("url")),
+		// pagerduty is reached through its events url and a routing key
+		RoutingKey: strings.TrimSpace(r.FormValue("routing_key")),
+	}
+	channel := Channel{
+		UserId: app.currentUserId(r),
+		Kind:   form.Kind,
+		Name:   nullify(form.Name),
+	}
+	notifier, err := findNotifier(form.Kind)
+	form.InvalidKind = err != nil
+	if notifier != nil {
+		form.ConfigError = notifier.Configure(app.worker.config, &channel, form.Url, form.RoutingKey)
+	}
+	if form.InvalidKind || form.ConfigError != "" {
+		app.renderChannels(w, r, form)
+		return
+	}
+	_, err = app.model.CreateChannel(channel)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	redirect(w, r, "/channels")
+}
+
+// testChannel queues a test alert so users can see a channel works
+func (app *App) testChannel(w http.ResponseWriter, r *http.Request) {
+	channelId, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	channel, err := app.model.FindChannel(channelId)
+	haltOn(err)
+	if channel == nil || channel.UserId != app.currentUserId(r) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	err = app.worker.notify(*channel, testAlert(app.worker.config))
+	if err != nil {
+		SetFlash(w, "error", []byte("Could not send a test notification: "+err.Error()))
+	} else {
+		SetFlash(w, "success", []byte("Test notification sent, it shows in the deliveries below"))
+	}
+	redirect(w, r, "/channels")
+}
+
+// routeChannel limits a channel to the checked sites, none means all sites
+func (app *App) routeChannel(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	err := app.model.RouteChannel(app.currentUserId(r), r.FormValue("id"), r.Form["site_id"])
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	SetFlash(w, "success", []byte("Channel sites saved"))
+	redirect(w, r, "/channels")
+}
+
+func (app *App) deleteChannel(w http.ResponseWriter, r *http.Request) {
+	_, err := app.model.DeleteChannel(app.currentUserId(r), r.FormValue("id"))
+	if err != nil {
+		SetFlash(w, "error", []byte("Could not delete channel"))
+	}
+	redirect(w, r, "/channels")
+}
+
+// webhookUrlError is the form's message for a url alerts can't be posted to,
+// private hosts are refused like they are for sites
+func webhookUrlError(config Config, raw string) string {
+	if raw == "" {
+		return "Url can't be blank"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "Webhook urls look like https://example.com/hook"
+	}
+	if !config.AllowPrivateTargets {
+		err = publicHost(u.Hostname())
+		if err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+const channelColumns = `
+	notification_channels.id, notification_channels.user_id, notification_channels.kind,
+	notification_channels.name, notification_channels.url, notification_channels.secret,
+	notification_channels.updated_at, notification_channels.created_at`
+
+func scanChannel(row interface{ Scan(...interface{}) error }) (Channel, error) {
+	channel := Channel{}
+	err := row.Scan(
+		&channel.Id, &channel.UserId, &channel.Kind,
+		&channel.Name, &channel.Url, &channel.Secret,
+		&channel.UpdatedAt, &channel.CreatedAt,
+	)
+	return channel, err
+}
+
+func (m *Model) CreateChannel(channel Channel) (Channel, error) {
+	return scanChannel(m.db.QueryRow(
+		`
+		insert into notification_channels (user_id, kind, name, url, secret)
+		values ($1, $2, $3, $4, $5)
+		returning `+channelColumns,
+		channel.UserId, channel.Kind, channel.Name, channel.Url, channel.Secret,
+	))
+}
+
+func (m *Model) ListChannels(userId int64) ([]Channel, error) {
+	rows, err := m.db.Query(
+		`
+		select `+channelColumns+`
+		from notification_channels
+		where user_id = $1
+		order by id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var channels []Channel
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return channels, m.loadSiteIds(channels)
+}
+
+func (m *Model) loadSiteIds(channels []Channel) error {
+	for i := range channels {
+		rows, err := m.db.Query(`select site_id from site_channels where channel_id = $1 order by site_id`, channels[i].Id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var siteId int64
+			err = rows.Scan(&siteId)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			channels[i].SiteIds = append(channels[i].SiteIds, siteId)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+	}
+	return nil
+}
+
+// SiteChannels are the channels a site's alerts go to
+func (m *Model) SiteChannels(site Site) ([]Channel, error) {
+	rows, err := m.db.Query(
+		`
+		select `+channelColumns+`
+		from notification_channels
+		where user_id = $1
+		and (
+			not exists (select 1 from site_channels where channel_id = notification_channels.id)
+			or exists (select 1 from site_channels where channel_id = notification_channels.id and site_id = $2)
+		)
+		order by id
+		`,
+		site.UserId, site.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var channels []Channel
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// RouteChannel replaces the sites a channel is routed to, ignoring sites
+// and channels that aren't the user's
+func (m *Model) RouteChannel(userId int64, channelId string, siteIds []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		`
+		delete from site_channels
+		where channel_id in (select id from notification_channels where id = $1 and user_id = $2)
+		`,
+		channelId, userId,
+	)
+	if err != nil {
+		return err
+	}
+	for _, siteId := range siteIds {
+		_, err = tx.Exec(
+			`
+			insert into site_channels (site_id, channel_id)
+			select sites.id, notification_channels.id
+			from sites, notification_channels
+			where sites.id = $1 and sites.user_id = $2
+			and notification_channels.id = $3 and notification_channels.user_id = $2
+			`,
+			siteId, userId, channelId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FindChannel returns the channel with the given id, or nil
+func (m *Model) FindChannel(id int64) (*Channel, error) {
+	channel, err := scanChannel(m.db.QueryRow(
+		`select `+channelColumns+` from notification_channels where id = $1`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (m *Model) DeleteChannel(userId int64, id string) (sql.Result, error) {
+	return m.db.Exec(`delete from notification_channels where user_id = $1 and id = $2`, userId, id)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Slack and Discord incoming webhooks, formatted as Block Kit blocks and
+// embeds
+
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+)
+
+func init() {
+	RegisterNotifier(KindSlack, chatNotifier{label: "Slack", format: slackPayload})
+	RegisterNotifier(KindDiscord, chatNotifier{label: "Discord", format: discordPayload})
+}
+
+// chatNotifier posts to an incoming webhook url
+type chatNotifier struct {
+	label  string
+	format func(Config, Alert) ([]byte, error)
+}
+
+func (n chatNotifier) Label() string {
+	return n.label
+}
+
+func (n chatNotifier) Configure(config Config, channel *Channel, url string, key string) string {
+	channel.Url = url
+	return webhookUrlError(config, url)
+}
+
+func (n chatNotifier) Format(config Config, channel Channel, alert Alert) (Message, error) {
+	payload, err := n.format(config, alert)
+	return Message{Target: channel.Url, Subject: alert.Event, Payload: string(payload)}, err
+}
+
+func (n chatNotifier) Send(config Config, channel Channel, delivery Delivery) (int, error) {
+	return postJson(targetDialer(config, webhookTimeout), channel.Url, []byte(delivery.Payload), nil)
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func slackPayload(config Config, alert Alert) ([]byte, error) {
+	emoji := map[string]string{AlertDown: ":red_circle:", AlertUp: ":large_green_circle:", AlertCert: ":warning:", AlertFlapping: ":warning:", AlertSettled: ":large_blue_circle:", AlertTest: ":wave:"}[alert.Event]
+	links := "<" + config.BaseUrl + "/|all your uptime>"
+	if alert.AckUrl != "" {
+		links += " | <" + alert.AckUrl + "|Acknowledge>"
+	}
+	return json.Marshal(slackMessage{
+		Text: alert.Headline(),
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: emoji + " " + alert.Headline()}},
+			{Type: "section", Fields: []slackText{
+				{Type: "mrkdwn", Text: "*Url*\n" + alert.Site.Url},
+				{Type: "mrkdwn", Text: "*Details*\n" + alert.Detail()},
+			}},
+			{Type: "context", Elements: []slackText{
+				{Type: "mrkdwn", Text: links + " at " + time.Unix(alert.At(), 0).UTC().Format(time.RFC1123)},
+			}},
+		},
+	})
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Url         string         `json:"url"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func discordPayload(config Config, alert Alert) ([]byte, error) {
+	color := map[string]int{AlertDown: 0xe5484d, AlertUp: 0x30a46c, AlertCert: 0xf5a623, AlertFlapping: 0xf5a623, AlertSettled: 0x0090ff, AlertTest: 0x0090ff}[alert.Event]
+	fields := []discordField{{Name: "Url", Value: alert.Site.Url}}
+	if alert.AckUrl != "" {
+		fields = append(fields, discordField{Name: "Acknowledge", Value: alert.AckUrl})
+	}
+	return json.Marshal(discordMessage{
+		Embeds: []discordEmbed{{
+			Title:       alert.Headline(),
+			Description: alert.Detail(),
+			Url:         config.BaseUrl + "/",
+			Color:       color,
+			Fields:      fields,
+			Timestamp:   time.Unix(alert.At(), 0).UTC().Format(time.RFC3339),
+		}},
+	})
+}
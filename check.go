@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of failed checks, stored on pings and incidents
+const (
+	ErrorDns       = "dns"
+	ErrorConnect   = "connect"
+	ErrorTls       = "tls"
+	ErrorTimeout   = "timeout"
+	ErrorProtocol  = "protocol"
+	ErrorHttp      = "http"
+	ErrorAssertion = "assertion"
+	ErrorHeartbeat = "heartbeat"
+)
+
+// check probes the site once and returns the result without storing it
+func (this Worker) check(site Site) Ping {
+	switch site.CheckType {
+	case CheckTcp:
+		return this.checkTcp(site)
+	case CheckDns:
+		return this.checkDns(site)
+	default:
+		return this.checkHttp(site)
+	}
+}
+
+func (this Worker) checkHttp(site Site) (ping Ping) {
+	start := time.Now()
+	ping = Ping{
+		SiteId:    site.Id,
+		CheckedAt: start.Unix(),
+	}
+	expected, err := ParseStatusRanges(site.ExpectedStatus)
+	if err != nil {
+		ping.ErrorKind = nullify(ErrorProtocol)
+		ping.Error = nullify(err.Error())
+		return ping
+	}
+	req, err := newRequest(site)
+	if err != nil {
+		ping.ErrorKind = nullify(ErrorProtocol)
+		ping.Error = nullify(err.Error())
+		return ping
+	}
+	capture := &certCapture{}
+	client := &http.Client{
+		Timeout: site.TimeoutDuration(),
+		// each check builds its own transport to capture the certificate,
+		// kept alive connections would outlive it
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyFromEnvironment,
+			DialContext:       this.dialer(site.TimeoutDuration()).DialContext,
+			TLSClientConfig:   capture.tlsConfig(),
+		},
+	}
+	// every return below carries whatever certificate the check saw
+	defer func() {
+		ping.Cert = capture.cert
+	}()
+	if expected.Redirect() {
+		// a redirect is the expected response, following it would hide it
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	res, err := client.Do(req)
+	ping.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		ping.ErrorKind = nullify(classify(err))
+		ping.Error = nullify(unwrapUrlError(err).Error())
+		if ping.ErrorKind.String == ErrorTimeout {
+			ping.Error = nullify(fmt.Sprintf("no response after %v", site.TimeoutDuration()))
+		}
+		return ping
+	}
+	defer res.Body.Close()
+	ping.StatusCode = res.StatusCode
+	ping.Up = expected.Contains(res.StatusCode)
+	if !ping.Up {
+		ping.ErrorKind = nullify(ErrorHttp)
+		ping.Error = nullify(fmt.Sprintf("%s, expected %s", res.Status, expected))
+		return ping
+	}
+	if site.Asserts() {
+		body, err := io.ReadAll(io.LimitReader(res.Body, maxBodyRead))
+		ping.Latency = time.Since(start).Milliseconds()
+		if err != nil {
+			ping.Up = false
+			ping.ErrorKind = nullify(classify(err))
+			ping.Error = nullify(err.Error())
+			return ping
+		}
+		if failed := assertBody(site, body); failed != "" {
+			ping.Up = false
+			ping.ErrorKind = nullify(ErrorAssertion)
+			ping.Error = nullify(failed)
+		}
+	}
+	return ping
+}
+
+// checkTcp connects to a tcp://host:port url, latency is the time to
+// connect. When the site has a body it is sent once connected, and when it
+// has BodyContains the response is read until it shows up.
+func (this Worker) checkTcp(site Site) Ping {
+	start := time.Now()
+	ping := Ping{
+		SiteId:    site.Id,
+		CheckedAt: start.Unix(),
+	}
+	address, err := tcpAddress(site.Url)
+	if err != nil {
+		ping.ErrorKind = nullify(ErrorProtocol)
+		ping.Error = nullify(err.Error())
+		return ping
+	}
+	conn, err := this.dialer(site.TimeoutDuration()).Dial("tcp", address)
+	ping.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		ping.ErrorKind = nullify(classify(err))
+		ping.Error = nullify(err.Error())
+		if ping.ErrorKind.String == ErrorTimeout {
+			ping.Error = nullify(fmt.Sprintf("no connection after %v", site.TimeoutDuration()))
+		}
+		return ping
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(start.Add(site.TimeoutDuration()))
+	if err != nil {
+		ping.ErrorKind = nullify(classify(err))
+		ping.Error = nullify(err.Error())
+		return ping
+	}
+
+	if site.Body != "" {
+		_, err = io.WriteString(conn, site.Body)
+		if err != nil {
+			ping.ErrorKind = nullify(classify(err))
+			ping.Error = nullify(err.Error())
+			return ping
+		}
+	}
+	if site.BodyContains != "" {
+		response, err := readUntil(conn, []byte(site.BodyContains))
+		if !bytes.Contains(response, []byte(site.BodyContains)) {
+			ping.ErrorKind = nullify(ErrorAssertion)
+			ping.Error = nullify(fmt.Sprintf("response does not contain %q", site.BodyContains))
+			if err != nil && err != io.EOF {
+				ping.Error = nullify(fmt.Sprintf("response does not contain %q: %v", site.BodyContains, err))
+			}
+			return ping
+		}
+	}
+	ping.Up = true
+	return ping
+}
+
+// tcpAddress returns the host:port of a tcp://host:port url
+func tcpAddress(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "tcp" || u.Hostname() == "" || u.Port() == "" {
+		return "", errors.New("tcp urls look like tcp://host:port")
+	}
+	return strings.ToLower(u.Host), nil
+}
+
+// readUntil reads from r until what it has read contains want, it gives up
+// after maxBodyRead bytes or when r fails
+func readUntil(r io.Reader, want []byte) ([]byte, error) {
+	var response []byte
+	buf := make([]byte, 4096)
+	for len(response) < maxBodyRead {
+		n, err := r.Read(buf)
+		response = append(response, buf[:n]...)
+		if bytes.Contains(response, want) {
+			return response, nil
+		}
+		if err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+func newRequest(site Site) (*http.Request, error) {
+	var body io.Reader
+	if site.Body != "" {
+		body = strings.NewReader(site.Body)
+	}
+	req, err := http.NewRequest(site.Method, site.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := ParseHeaders(site.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	if host := headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// ParseHeaders reads request headers written one "Name: value" per line,
+// blank lines are skipped
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", line)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// StatusRanges is a set of status codes written like "200-299,301"
+type StatusRanges []StatusRange
+
+type StatusRange struct {
+	From int
+	To   int
+}
+
+func ParseStatusRanges(s string) (StatusRanges, error) {
+	var ranges StatusRanges
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		r := StatusRange{}
+		var err error
+		r.From, err = strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		r.To = r.From
+		if isRange {
+			r.To, err = strconv.Atoi(strings.TrimSpace(to))
+			if err != nil {
+				return nil, fmt.Errorf("invalid status range %q", part)
+			}
+		}
+		if r.From < 100 || r.To > 599 || r.From > r.To {
+			return nil, fmt.Errorf("invalid status range %q", part)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no expected status")
+	}
+	return ranges, nil
+}
+
+func (ranges StatusRanges) Contains(code int) bool {
+	for _, r := range ranges {
+		if code >= r.From && code <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Redirect reports whether any 3xx status is expected
+func (ranges StatusRanges) Redirect() bool {
+	for _, r := range ranges {
+		if r.From <= 399 && r.To >= 300 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ranges StatusRanges) String() string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = strconv.Itoa(r.From)
+		if r.To != r.From {
+			parts[i] += "-" + strconv.Itoa(r.To)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// classify sorts a transport error into one of the error kinds
+func classify(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDns
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ErrorTls
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// the server sent a tls alert
+		return ErrorTls
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrorConnect
+	default:
+		return ErrorProtocol
+	}
+}
+
+// unwrapUrlError drops the method and url net/http puts in front of every
+// error, the site's url is already known
+func unwrapUrlError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+var errorLabels = map[string]string{
+	ErrorDns:       "DNS lookup failed",
+	ErrorConnect:   "Could not connect",
+	ErrorTls:       "TLS error",
+	ErrorTimeout:   "Timed out",
+	ErrorProtocol:  "Protocol error",
+	ErrorHttp:      "HTTP",
+	ErrorAssertion: "Assertion failed",
+	ErrorHeartbeat: "Heartbeat",
+}
+
+// reason describes a failure for people, e.g. "Could not connect: connection
+// refused" or "HTTP 503 Service Unavailable"
+func reason(kind sql.NullString, message sql.NullString, statusCode int) string {
+	label, ok := errorLabels[kind.String]
+	switch {
+	case kind.String == ErrorHttp:
+		return label + " " + message.String
+	case ok && message.Valid:
+		return label + ": " + message.String
+	case ok:
+		return label
+	case message.Valid:
+		return message.String
+	default:
+		return http.StatusText(statusCode)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings read from the environment on boot
+type Config struct {
+	// Workers is how many checks run at the same time
+	Workers int
+	// CertWarnDays are the days before a certificate expires at which a site
+	// gets a warning
+	CertWarnDays []int
+	// BaseUrl is where this app is reached, for links in alerts
+	BaseUrl string
+	// SmtpHost is the mail server alerts are sent through, email alerts are
+	// off without it
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUsername string
+	SmtpPassword string
+	SmtpFrom     string
+	// SmtpTls is "starttls", "tls" for implicit TLS, or "none" for a local
+	// catcher
+	SmtpTls string
+	// PagerdutyUrl is the Events API base url, changed to test against a
+	// stand in
+	PagerdutyUrl string
+	// A site that changes status FlapThreshold times within FlapWindow is
+	// flapping and its alerts are paused until it settles
+	FlapThreshold int
+	FlapWindow    time.Duration
+	// AllowPrivateTargets lets sites check loopback and private addresses,
+	// for self hosting next to the services being checked
+	AllowPrivateTargets bool
+}
+
+func NewConfig() Config {
+	return Config{
+		Workers:      envInt("WORKERS", 16),
+		CertWarnDays: envInts("CERT_WARN_DAYS", []int{30, 14, 7}),
+		BaseUrl:      strings.TrimSuffix(envString("BASE_URL", "http://localhost:9001"), "/"),
+		SmtpHost:     os.Getenv("SMTP_HOST"),
+		SmtpPort:     envInt("SMTP_PORT", 587),
+		SmtpUsername: os.Getenv("SMTP_USERNAME"),
+		SmtpPassword: os.Getenv("SMTP_PASSWORD"),
+		SmtpFrom:     envString("SMTP_FROM", "alerts@localhost"),
+		SmtpTls:      envString("SMTP_TLS", "starttls"),
+		PagerdutyUrl: strings.TrimSuffix(envString("PAGERDUTY_URL", "https://events.pagerduty.com"), "/"),
+		// FLAP_WINDOW is in minutes
+		FlapThreshold:       envInt("FLAP_THRESHOLD", 5),
+		FlapWindow:          time.Duration(envInt("FLAP_WINDOW", 30)) * time.Minute,
+		AllowPrivateTargets: envBool("ALLOW_PRIVATE_TARGETS"),
+	}
+}
+
+func envString(name string, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// envBool is true for "1" or "true"
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && value
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// envInts reads a comma separated list like "30,14,7"
+func envInts(name string, fallback []int) []int {
+	var values []int
+	for _, part := range strings.Split(os.Getenv(name), ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value <= 0 {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five field cron expression, "minute hour
+// day-of-month month day-of-week", evaluated in UTC. Fields take *, numbers,
+// ranges like 1-5, lists like 1,15 and steps like */15 or 0-30/10. Day of
+// week runs 0-6 from Sunday, 7 is Sunday too. Like cron, when both day
+// fields are restricted a day matching either one matches.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// cronHorizon bounds how far Next looks for a schedule that can never run,
+// like the 31st of February
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+func ParseSchedule(expression string) (Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Schedule{}, errors.New("schedules have 5 fields, minute hour day month weekday")
+	}
+	s := Schedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	bounds := []struct {
+		name     string
+		bits     *uint64
+		min, max int
+	}{
+		{"minute", &s.minutes, 0, 59},
+		{"hour", &s.hours, 0, 23},
+		{"day", &s.days, 1, 31},
+		{"month", &s.months, 1, 12},
+		{"weekday", &s.weekdays, 0, 7},
+	}
+	for i, b := range bounds {
+		*b.bits, err = parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s %q, %s", b.name, fields[i], err)
+		}
+	}
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New("steps are whole numbers above 0")
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New("expected a number, range or *")
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, errors.New("expected a number, range or *")
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("values run from %d to %d", min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Next is the first time at or after t the schedule runs, the zero time
+// when it never does
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.UTC()
+	if t.Truncate(time.Minute) != t {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+	}
+	limit := t.Add(cronHorizon)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+	}
+	for _, test := range tests {
+		_, err := ParseSchedule(test)
+		if err == nil {
+			t.Errorf("ParseSchedule(%q) = nil error, want one", test)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// a Saturday
+	from := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		from       time.Time
+		next       time.Time
+	}{
+		{"0 12 * * *", from, from},
+		{"0 12 * * *", from.Add(30 * time.Second), time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", from.Add(time.Minute), time.Date(2026, 10, 17, 12, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", from.Add(30 * time.Minute), time.Date(2026, 10, 17, 12, 45, 0, 0, time.UTC)},
+		{"0-30/10 13 * * *", from, time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", from, time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)},
+		// Sunday is both 0 and 7
+		{"0 0 * * 0", from, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		// with both day fields restricted either one matches
+		{"0 0 1,15 * 5", from, time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * 1", from, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * 4", time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		// with one day field left as * only the other counts
+		{"0 0 13 * *", from, time.Date(2026, 11, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * 12 5", from, time.Date(2026, 12, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// never runs
+		{"0 0 31 2 *", from, time.Time{}},
+		{"0 0 30 2 *", from, time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.expression)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", test.expression, err)
+		}
+		next := schedule.Next(test.from)
+		if !next.Equal(test.next) {
+			t.Errorf("%q Next(%v) = %v, want %v", test.expression, test.from, next, test.next)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record types a dns check can resolve
+var dnsRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT"}
+
+// checkDns resolves the site's name, which is stored as its url, and compares
+// the records with the expected values. Latency is the time to resolve.
+func (this Worker) checkDns(site Site) Ping {
+	start := time.Now()
+	ping := Ping{
+		SiteId:    site.Id,
+		CheckedAt: start.Unix(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), site.TimeoutDuration())
+	defer cancel()
+	records, err := resolve(ctx, this.resolver(site), site.Url, site.DnsRecordType)
+	ping.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && site.DnsResolver != "" {
+			// the error names the address the resolver was asked to dial
+			dnsErr.Server = site.DnsResolver
+		}
+		ping.ErrorKind = nullify(classify(err))
+		ping.Error = nullify(err.Error())
+		return ping
+	}
+	expected := dnsValues(site.DnsRecordType, site.DnsExpected)
+	if len(records) == 0 || (len(expected) > 0 && strings.Join(records, "\n") != strings.Join(expected, "\n")) {
+		ping.ErrorKind = nullify(ErrorAssertion)
+		ping.Error = nullify(fmt.Sprintf("expected %s %s, got %s", site.DnsRecordType, describeRecords(expected), describeRecords(records)))
+		return ping
+	}
+	ping.Up = true
+	return ping
+}
+
+// resolver returns a resolver that asks the site's address:port through the
+// worker's dialer, or the system's resolver when it is blank
+func (this Worker) resolver(site Site) *net.Resolver {
+	if site.DnsResolver == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return this.dialer(site.TimeoutDuration()).DialContext(ctx, network, site.DnsResolver)
+		},
+	}
+}
+
+// resolve looks up records of the given type and returns their values
+// normalized and sorted so they compare with dnsValues
+func resolve(ctx context.Context, resolver *net.Resolver, name string, recordType string) ([]string, error) {
+	var values []string
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	case "CNAME":
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, cname)
+	case "MX":
+		mxs, err := resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			values = append(values, mx.Host)
+		}
+	case "TXT":
+		txts, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, txts...)
+	default:
+		return nil, errors.New("unknown record type " + recordType)
+	}
+	return normalizeRecords(recordType, values), nil
+}
+
+// dnsValues reads expected values written one per line
+func dnsValues(recordType string, s string) []string {
+	var values []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return normalizeRecords(recordType, values)
+}
+
+// normalizeRecords lowercases names and drops their trailing dot, txt values
+// are compared as is
+func normalizeRecords(recordType string, values []string) []string {
+	normalized := make([]string, len(values))
+	for i, value := range values {
+		if recordType != "TXT" {
+			value = strings.TrimSuffix(strings.ToLower(value), ".")
+		}
+		normalized[i] = value
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func describeRecords(values []string) string {
+	if len(values) == 0 {
+		return "no records"
+	}
+	return strings.Join(values, ", ")
+}
+
+// dnsName checks a name to resolve, it is a host name without a scheme
+func dnsName(raw string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), ".")
+	if name == "" || strings.ContainsAny(name, ":/ ") {
+		return "", errors.New("DNS checks take a name like example.com")
+	}
+	return name, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email channels send to an address, a user's profile email gets alerts for
+// all their sites as if it were one
+
+const KindEmail = "email"
+
+const smtpTimeout = 30 * time.Second
+
+func init() {
+	RegisterNotifier(KindEmail, emailNotifier{})
+}
+
+type emailNotifier struct{}
+
+func (emailNotifier) Label() string {
+	return "Email"
+}
+
+func (emailNotifier) Configure(config Config, channel *Channel, url string, key string) string {
+	address, err := mail.ParseAddress(url)
+	if err != nil {
+		return "Email addresses look like you@example.com"
+	}
+	channel.Url = address.Address
+	return ""
+}
+
+func (emailNotifier) Format(config Config, channel Channel, alert Alert) (Message, error) {
+	subject, body := emailAlert(config, alert)
+	return Message{Target: channel.Url, Subject: subject, Payload: body}, nil
+}
+
+func (emailNotifier) Send(config Config, channel Channel, delivery Delivery) (int, error) {
+	if config.SmtpHost == "" {
+		return 0, errors.New("SMTP_HOST is not set")
+	}
+	return 0, sendEmail(config, delivery.Target, delivery.Subject, delivery.Payload)
+}
+
+// emailAlert writes the subject and body of an alert's email
+func emailAlert(config Config, alert Alert) (string, string) {
+	site, incident := alert.Site, alert.Incident
+	var body strings.Builder
+	switch alert.Event {
+	case AlertDown:
+		fmt.Fprintf(&body, "%s went down at %s.\n\n", site.Title(), time.Unix(incident.StartedAt, 0).UTC().Format(time.RFC1123))
+		fmt.Fprintf(&body, "Reason: %s\n", alert.Detail())
+		if alert.AckUrl != "" {
+			fmt.Fprintf(&body, "Acknowledge: %s\n", alert.AckUrl)
+		}
+	case AlertUp:
+		fmt.Fprintf(&body, "%s is back up after %v of downtime.\n\n", site.Title(), incident.Duration())
+		fmt.Fprintf(&body, "It went down at %s: %s\n", time.Unix(incident.StartedAt, 0).UTC().Format(time.RFC1123), incident.Reason())
+	default:
+		fmt.Fprintf(&body, "%s, %s.\n", alert.Headline(), alert.Detail())
+	}
+	fmt.Fprintf(&body, "Url: %s\n\n", site.Url)
+	fmt.Fprintf(&body, "%s/\n", config.BaseUrl)
+	return alert.Headline(), body.String()
+}
+
+// sendEmail sends a plain text email through the configured SMTP server
+func sendEmail(config Config, to string, subject string, body string) error {
+	address := net.JoinHostPort(config.SmtpHost, strconv.Itoa(config.SmtpPort))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if config.SmtpTls == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: config.SmtpHost})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, config.SmtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.SmtpTls == "starttls" {
+		err = client.StartTLS(&tls.Config{ServerName: config.SmtpHost})
+		if err != nil {
+			return err
+		}
+	}
+	if config.SmtpUsername != "" {
+		err = client.Auth(smtp.PlainAuth("", config.SmtpUsername, config.SmtpPassword, config.SmtpHost))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(config.SmtpFrom)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(emailMessage(config.SmtpFrom, to, subject, body))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func emailMessage(from string, to string, subject string, body string) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(message.String())
+}
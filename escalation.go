@@ -0,0 +1,609 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Escalation policies page channels one step at a time until an incident is
+// acknowledged or resolved. Each step notifies a channel and waits its delay
+// before the next, after the last step it starts over. The next step's due
+// time is stored on the incident so escalation carries on after a restart.
+
+const (
+	escalationTick     = 15 * time.Second
+	escalationRows     = 3
+	maxEscalationDelay = 24 * 60
+)
+
+type EscalationPolicy struct {
+	Id        int64
+	UserId    int64
+	Name      string
+	Steps     []EscalationStep
+	SiteIds   []int64
+	UpdatedAt sql.NullInt64
+	CreatedAt int64
+}
+
+// Covers reports whether the policy is the site's
+func (p EscalationPolicy) Covers(siteId int64) bool {
+	for _, id := range p.SiteIds {
+		if id == siteId {
+			return true
+		}
+	}
+	return false
+}
+
+type EscalationStep struct {
+	Id           int64
+	PolicyId     int64
+	Position     int
+	ChannelId    int64
+	DelayMinutes int
+	Channel      Channel
+}
+
+func (s EscalationStep) Delay() time.Duration {
+	return time.Duration(s.DelayMinutes) * time.Minute
+}
+
+type Escalations struct {
+	Policies       []EscalationPolicy
+	Channels       []Channel
+	Sites          []Site
+	Name           string
+	BlankName      bool
+	Rows           []EscalationRow
+	NoSteps        bool
+	InvalidChannel bool
+	InvalidDelay   bool
+}
+
+// EscalationRow is a step as entered in the form
+type EscalationRow struct {
+	Position  int
+	ChannelId string
+	Delay     string
+}
+
+type Acknowledge struct {
+	Token    string
+	Incident *Incident
+	Site     *Site
+}
+
+// escalations runs forever, notifying the next step of every incident whose
+// step is due
+func (this Worker) escalations() {
+	for {
+		incidents, err := this.model.DueEscalations(time.Now().Unix())
+		if err != nil {
+			this.logger.Printf("message=Could not find due escalations error=%q", err)
+		}
+		for _, incident := range incidents {
+			this.escalateDue(incident)
+		}
+		time.Sleep(escalationTick)
+	}
+}
+
+func (this Worker) escalateDue(incident Incident) {
+	site, err := this.model.FindSite(incident.SiteId)
+	if err != nil {
+		this.logger.Printf("message=Could not find site site_id=%d error=%q", incident.SiteId, err)
+		return
+	}
+	if site != nil && this.inMaintenance(*site) {
+		// the step stays due and goes out once the window is over
+		return
+	}
+	steps := []EscalationStep{}
+	if site != nil && site.EscalationPolicyId.Valid {
+		steps, err = this.model.EscalationSteps(site.EscalationPolicyId.Int64)
+		if err != nil {
+			this.logger.Printf("message=Could not find escalation steps site_id=%d error=%q", site.Id, err)
+			return
+		}
+	}
+	if len(steps) == 0 {
+		// the policy was removed from the site, stop escalating
+		err = this.model.Escalated(incident.Id, incident.EscalationStep, sql.NullInt64{})
+		if err != nil {
+			this.logger.Printf("message=Could not stop escalation incident_id=%d error=%q", incident.Id, err)
+		}
+		return
+	}
+	this.escalate(Alert{
+		Event: AlertDown,
+		Site:  *site,
+		Ping: Ping{
+			SiteId:     site.Id,
+			StatusCode: incident.StatusCode,
+			Error:      incident.Error,
+			ErrorKind:  incident.ErrorKind,
+			CheckedAt:  incident.StartedAt,
+		},
+		Transition: Transition{SiteId: site.Id, FromStatus: nullify(StatusUp), ToStatus: StatusDown},
+		Incident:   incident,
+		AckUrl:     this.ackUrl(incident),
+	}, steps)
+}
+
+// escalate notifies the incident's next step and schedules the one after
+func (this Worker) escalate(alert Alert, steps []EscalationStep) {
+	incident := alert.Incident
+	step := steps[incident.EscalationStep%len(steps)]
+	alert.Escalation = incident.EscalationStep
+	channel := step.Channel
+	err := this.notify(channel, alert)
+	if err != nil {
+		this.logger.Printf("message=Could not queue escalation incident_id=%d channel_id=%d error=%q", incident.Id, channel.Id, err)
+	}
+	next := sql.NullInt64{Int64: time.Now().Add(step.Delay()).Unix(), Valid: true}
+	err = this.model.Escalated(incident.Id, incident.EscalationStep+1, next)
+	if err != nil {
+		this.logger.Printf("message=Could not record escalation incident_id=%d error=%q", incident.Id, err)
+		return
+	}
+	this.logger.Printf("message=Escalated incident incident_id=%d step=%d channel_id=%d", incident.Id, incident.EscalationStep+1, channel.Id)
+}
+
+// alertEscalation starts escalating a site's incident, recoveries go to
+// every channel that was paged
+func (this Worker) alertEscalation(alert Alert) {
+	steps, err := this.model.EscalationSteps(alert.Site.EscalationPolicyId.Int64)
+	if err != nil {
+		this.logger.Printf("message=Could not find escalation steps site_id=%d error=%q", alert.Site.Id, err)
+	}
+	if len(steps) == 0 {
+		this.alertChannels(alert)
+		return
+	}
+	if alert.Event == AlertDown {
+		this.escalate(alert, steps)
+		return
+	}
+	paged := map[int64]bool{}
+	for i := 0; i < alert.Incident.EscalationStep && i < len(steps); i++ {
+		channel := steps[i].Channel
+		if paged[channel.Id] {
+			continue
+		}
+		paged[channel.Id] = true
+		err = this.notify(channel, alert)
+		if err != nil {
+			this.logger.Printf("message=Could not queue alert site_id=%d channel_id=%d error=%q", alert.Site.Id, channel.Id, err)
+		}
+	}
+}
+
+func (this Worker) ackUrl(incident Incident) string {
+	if !incident.AckToken.Valid {
+		return ""
+	}
+	return this.config.BaseUrl + "/acknowledge?token=" + incident.AckToken.String
+}
+
+func (app *App) escalations(w http.ResponseWriter, r *http.Request) {
+	form := Escalations{}
+	for i := 0; i < escalationRows; i++ {
+		form.Rows = append(form.Rows, EscalationRow{Position: i + 1})
+	}
+	app.renderEscalations(w, r, form)
+}
+
+func (app *App) renderEscalations(w http.ResponseWriter, r *http.Request, form Escalations) {
+	userId := app.currentUserId(r)
+	policies, err := app.model.ListPolicies(userId)
+	haltOn(err)
+	channels, err := app.model.ListChannels(userId)
+	haltOn(err)
+	form.Policies = policies
+	form.Channels = channels
+	form.Sites = app.model.ListSites(userId)
+	successFlash, err := GetFlash(w, r, "success")
+	haltOn(err)
+	errorFlash, err := GetFlash(w, r, "error")
+	haltOn(err)
+	app.render(w, r, "escalations", View{SuccessFlash: string(successFlash), ErrorFlash: string(errorFlash), Escalations: form})
+}
+
+func (app *App) createEscalation(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	form := Escalations{Name: strings.TrimSpace(r.FormValue("name"))}
+	form.BlankName = form.Name == ""
+	policy := EscalationPolicy{UserId: app.currentUserId(r), Name: form.Name}
+	channels, err := app.model.ListChannels(policy.UserId)
+	haltOn(err)
+	owned := map[int64]bool{}
+	for _, channel := range channels {
+		owned[channel.Id] = true
+	}
+	channelIds := r.Form["channel_id"]
+	delays := r.Form["delay"]
+	for i := 0; i < escalationRows; i++ {
+		row := EscalationRow{Position: i + 1}
+		if i < len(channelIds) {
+			row.ChannelId = channelIds[i]
+		}
+		if i < len(delays) {
+			row.Delay = strings.TrimSpace(delays[i])
+		}
+		form.Rows = append(form.Rows, row)
+		if row.ChannelId == "" {
+			continue
+		}
+		channelId, err := strconv.ParseInt(row.ChannelId, 10, 64)
+		if err != nil || !owned[channelId] {
+			form.InvalidChannel = true
+			continue
+		}
+		delay, err := strconv.Atoi(row.Delay)
+		if err != nil || delay < 1 || delay > maxEscalationDelay {
+			form.InvalidDelay = true
+		}
+		policy.Steps = append(policy.Steps, EscalationStep{ChannelId: channelId, DelayMinutes: delay})
+	}
+	form.NoSteps = len(policy.Steps) == 0 && !form.InvalidChannel
+	if form.BlankName || form.NoSteps || form.InvalidChannel || form.InvalidDelay {
+		app.renderEscalations(w, r, form)
+		return
+	}
+	err = app.model.CreatePolicy(policy)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	redirect(w, r, "/escalations")
+}
+
+// assignEscalation makes a policy the one for the checked sites
+func (app *App) assignEscalation(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	err := app.model.AssignPolicy(app.currentUserId(r), r.FormValue("id"), r.Form["site_id"])
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	SetFlash(w, "success", []byte("Escalation sites saved"))
+	redirect(w, r, "/escalations")
+}
+
+func (app *App) deleteEscalation(w http.ResponseWriter, r *http.Request) {
+	_, err := app.model.DeletePolicy(app.currentUserId(r), r.FormValue("id"))
+	if err != nil {
+		SetFlash(w, "error", []byte("Could not delete escalation policy"))
+	}
+	redirect(w, r, "/escalations")
+}
+
+// acknowledge is where the link in an alert goes, it is public since the
+// token is the permission, and asks before acknowledging so link previews
+// don't
+func (app *App) acknowledge(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	incident, err := app.model.FindIncidentByToken(token)
+	haltOn(err)
+	if incident == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	site, err := app.model.FindSite(incident.SiteId)
+	haltOn(err)
+	app.render(w, r, "acknowledge", View{Acknowledge: Acknowledge{Token: token, Incident: incident, Site: site}})
+}
+
+// acknowledgeToken stops escalation from the link in an alert, it is public
+// like acknowledge
+func (app *App) acknowledgeToken(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	incident, err := app.model.AcknowledgeByToken(token)
+	haltOn(err)
+	if incident != nil {
+		app.logger.Printf("message=Incident acknowledged site_id=%d incident_id=%d", incident.SiteId, incident.Id)
+	} else {
+		// already acknowledged or resolved, show which
+		incident, err = app.model.FindIncidentByToken(token)
+		haltOn(err)
+	}
+	if incident == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	site, err := app.model.FindSite(incident.SiteId)
+	haltOn(err)
+	app.render(w, r, "acknowledge", View{Acknowledge: Acknowledge{Incident: incident, Site: site}})
+}
+
+// acknowledgeIncident stops escalation of one of the user's incidents from
+// the dashboard
+func (app *App) acknowledgeIncident(w http.ResponseWriter, r *http.Request) {
+	incident, err := app.model.AcknowledgeIncident(app.currentUserId(r), r.FormValue("id"))
+	haltOn(err)
+	if incident != nil {
+		app.logger.Printf("message=Incident acknowledged site_id=%d incident_id=%d", incident.SiteId, incident.Id)
+		SetFlash(w, "success", []byte("Incident acknowledged, escalation stopped"))
+	}
+	redirect(w, r, "/")
+}
+
+// CreatePolicy stores a policy and its steps, it fails when a step's channel
+// isn't the user's
+func (m *Model) CreatePolicy(policy EscalationPolicy) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var policyId int64
+	err = tx.QueryRow(
+		`insert into escalation_policies (user_id, name) values ($1, $2) returning id`,
+		policy.UserId, policy.Name,
+	).Scan(&policyId)
+	if err != nil {
+		return err
+	}
+	for i, step := range policy.Steps {
+		// only the user's own channels can be a step
+		result, err := tx.Exec(
+			`
+			insert into escalation_steps (policy_id, position, channel_id, delay_minutes)
+			select $1, $2, id, $3 from notification_channels where id = $4 and user_id = $5
+			`,
+			policyId, i, step.DelayMinutes, step.ChannelId, policy.UserId,
+		)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return fmt.Errorf("channel %d is not the user's", step.ChannelId)
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Model) ListPolicies(userId int64) ([]EscalationPolicy, error) {
+	rows, err := m.db.Query(
+		`
+		select id, user_id, name, updated_at, created_at
+		from escalation_policies
+		where user_id = $1
+		order by id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var policies []EscalationPolicy
+	for rows.Next() {
+		policy := EscalationPolicy{}
+		err = rows.Scan(&policy.Id, &policy.UserId, &policy.Name, &policy.UpdatedAt, &policy.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	for i := range policies {
+		policies[i].Steps, err = m.EscalationSteps(policies[i].Id)
+		if err != nil {
+			return nil, err
+		}
+		policies[i].SiteIds, err = m.policySiteIds(policies[i].Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+func (m *Model) policySiteIds(policyId int64) ([]int64, error) {
+	rows, err := m.db.Query(`select id from sites where escalation_policy_id = $1 order by id`, policyId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var siteIds []int64
+	for rows.Next() {
+		var siteId int64
+		err = rows.Scan(&siteId)
+		if err != nil {
+			return nil, err
+		}
+		siteIds = append(siteIds, siteId)
+	}
+	return siteIds, rows.Err()
+}
+
+// EscalationSteps are a policy's steps in order, with their channels
+func (m *Model) EscalationSteps(policyId int64) ([]EscalationStep, error) {
+	rows, err := m.db.Query(
+		`
+		select escalation_steps.id, escalation_steps.policy_id, escalation_steps.position,
+			escalation_steps.channel_id, escalation_steps.delay_minutes, `+channelColumns+`
+		from escalation_steps
+		join notification_channels on notification_channels.id = escalation_steps.channel_id
+		where escalation_steps.policy_id = $1
+		order by escalation_steps.position
+		`,
+		policyId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var steps []EscalationStep
+	for rows.Next() {
+		step := EscalationStep{}
+		channel := &step.Channel
+		err = rows.Scan(
+			&step.Id, &step.PolicyId, &step.Position, &step.ChannelId, &step.DelayMinutes,
+			&channel.Id, &channel.UserId, &channel.Kind,
+			&channel.Name, &channel.Url, &channel.Secret,
+			&channel.UpdatedAt, &channel.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// AssignPolicy makes a policy the one for the given sites and takes it off
+// the others
+func (m *Model) AssignPolicy(userId int64, policyId string, siteIds []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		`update sites set escalation_policy_id = null where escalation_policy_id = $1 and user_id = $2`,
+		policyId, userId,
+	)
+	if err != nil {
+		return err
+	}
+	for _, siteId := range siteIds {
+		_, err = tx.Exec(
+			`
+			update sites set escalation_policy_id = $1, updated_at = unixepoch()
+			where id = $2 and user_id = $3
+			and exists (select 1 from escalation_policies where id = $1 and user_id = $3)
+			`,
+			policyId, siteId, userId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Model) DeletePolicy(userId int64, id string) (sql.Result, error) {
+	return m.db.Exec(`delete from escalation_policies where user_id = $1 and id = $2`, userId, id)
+}
+
+// Escalated records how many steps an incident has notified and when the
+// next is due, a null next stops escalating
+func (m *Model) Escalated(incidentId int64, step int, next sql.NullInt64) error {
+	_, err := m.db.Exec(
+		`
+		update incidents
+		set escalation_step = $1, escalate_at = $2, updated_at = unixepoch()
+		where id = $3
+		`,
+		step, next, incidentId,
+	)
+	return err
+}
+
+func (m *Model) DueEscalations(now int64) ([]Incident, error) {
+	rows, err := m.db.Query(
+		`
+		select `+incidentColumns+`
+		from incidents
+		where escalate_at <= $1
+		and resolved_at is null
+		and acknowledged_at is null
+		and site_id not in (select id from sites where paused_at is not null)
+		order by escalate_at
+		`,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var incidents []Incident
+	for rows.Next() {
+		incident, err := scanIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, rows.Err()
+}
+
+// FindIncidentByToken returns the incident an acknowledge link is for, or nil
+func (m *Model) FindIncidentByToken(token string) (*Incident, error) {
+	if token == "" {
+		return nil, nil
+	}
+	incident, err := scanIncident(m.db.QueryRow(
+		`select `+incidentColumns+` from incidents where ack_token = $1`,
+		token,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// AcknowledgeByToken acknowledges the open incident an acknowledge link is
+// for, and returns nil if there was none to acknowledge
+func (m *Model) AcknowledgeByToken(token string) (*Incident, error) {
+	if token == "" {
+		return nil, nil
+	}
+	return m.acknowledge(`ack_token = $1`, token)
+}
+
+// AcknowledgeIncident acknowledges an open incident of one of the user's
+// sites, and returns nil if there was none to acknowledge
+func (m *Model) AcknowledgeIncident(userId int64, id string) (*Incident, error) {
+	return m.acknowledge(`id = $1 and site_id in (select id from sites where user_id = $2)`, id, userId)
+}
+
+func (m *Model) acknowledge(where string, args ...interface{}) (*Incident, error) {
+	incident, err := scanIncident(m.db.QueryRow(
+		`
+		update incidents
+		set acknowledged_at = unixepoch(), escalate_at = null, updated_at = unixepoch()
+		where resolved_at is null
+		and acknowledged_at is null
+		and `+where+`
+		returning `+incidentColumns,
+		args...,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// FindSite returns the site with the given id, or nil
+func (m *Model) FindSite(id int64) (*Site, error) {
+	site := Site{}
+	err := scanSite(m.db.QueryRow(`select `+siteColumns+` from sites where id = $1`, id), &site)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A site that changes status FlapThreshold times within FlapWindow is
+// flapping. Its incidents are still recorded but not alerted, one summary
+// says it is flapping, and alerting resumes once its status has held for a
+// whole window. Recoveries of incidents that were alerted before it started
+// flapping still go out, so pages opened for them get resolved.
+
+// flapping checks whether a transition starts the site flapping and
+// reports whether the site is flapping
+func (this Worker) flapping(site Site, transition Transition) bool {
+	since := time.Unix(transition.CreatedAt, 0).Add(-this.config.FlapWindow).Unix()
+	flaps, err := this.model.CountTransitions(site.Id, since)
+	if err != nil {
+		this.logger.Printf("message=Could not count transitions site_id=%d error=%q", site.Id, err)
+		return false
+	}
+	if flaps < this.config.FlapThreshold {
+		flapping, err := this.model.Flapping(site.Id)
+		if err != nil {
+			this.logger.Printf("message=Could not find whether site is flapping site_id=%d error=%q", site.Id, err)
+			// go by the site as it was loaded rather than alert by mistake
+			return site.FlappingSince.Valid
+		}
+		return flapping
+	}
+	started, err := this.model.StartFlapping(site.Id, transition.CreatedAt)
+	if err != nil {
+		this.logger.Printf("message=Could not mark site flapping site_id=%d error=%q", site.Id, err)
+		return true
+	}
+	if started {
+		this.logger.Printf("message=Site is flapping site_id=%d transitions=%d window=%v", site.Id, flaps, this.config.FlapWindow)
+		site.Status = nullify(transition.ToStatus)
+		this.summarize(Alert{Event: AlertFlapping, Site: site, Transition: transition, Flaps: flaps, Window: this.config.FlapWindow})
+	}
+	return true
+}
+
+// settle stops a flapping site flapping once its status has held for a
+// window, an incident that is still open is alerted like a new one
+func (this Worker) settle(site Site, ping Ping) {
+	fresh, err := this.model.FindSite(site.Id)
+	if err != nil || fresh == nil {
+		return
+	}
+	if !fresh.FlappingSince.Valid || time.Unix(fresh.StatusChangedAt.Int64, 0).Add(this.config.FlapWindow).After(time.Now()) {
+		return
+	}
+	settled, err := this.model.StopFlapping(site.Id)
+	if err != nil || !settled {
+		return
+	}
+	this.logger.Printf("message=Site stopped flapping site_id=%d status=%s", site.Id, fresh.Status.String)
+	if fresh.Status.String != StatusDown {
+		this.summarize(Alert{Event: AlertSettled, Site: *fresh, Ping: ping, Transition: Transition{ToStatus: fresh.Status.String}, Window: this.config.FlapWindow})
+		return
+	}
+	incident, err := this.model.OpenIncidentFor(site.Id)
+	if err != nil || incident == nil {
+		return
+	}
+	err = this.model.AlertedIncident(incident.Id)
+	if err != nil {
+		this.logger.Printf("message=Could not mark incident alerted incident_id=%d error=%q", incident.Id, err)
+	}
+	this.alert(*fresh, ping, Transition{SiteId: site.Id, FromStatus: nullify(StatusUp), ToStatus: StatusDown}, *incident)
+}
+
+// summarize sends a flapping summary to the user's email and the site's
+// channels
+func (this Worker) summarize(alert Alert) {
+	this.alertEmail(alert)
+	this.alertChannels(alert)
+}
+
+func (m *Model) CountTransitions(siteId int64, since int64) (int, error) {
+	var count int
+	err := scan(m.db.QueryRow(
+		`
+		select count(*) from transitions
+		where site_id = $1 and created_at > $2 and from_status is not null
+		`,
+		siteId, since,
+	), &count)
+	return count, err
+}
+
+func (m *Model) Flapping(siteId int64) (bool, error) {
+	var flappingSince sql.NullInt64
+	err := scan(m.db.QueryRow(`select flapping_since from sites where id = $1`, siteId), &flappingSince)
+	return flappingSince.Valid, err
+}
+
+// StartFlapping marks a site flapping and reports whether it wasn't already
+func (m *Model) StartFlapping(siteId int64, at int64) (bool, error) {
+	result, err := m.db.Exec(
+		`update sites set flapping_since = $1 where id = $2 and flapping_since is null`,
+		at, siteId,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// StopFlapping reports whether the site was flapping
+func (m *Model) StopFlapping(siteId int64) (bool, error) {
+	result, err := m.db.Exec(`update sites set flapping_since = null where id = $1 and flapping_since is not null`, siteId)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// SuppressIncident records that an incident opened without an alert
+func (m *Model) SuppressIncident(incidentId int64) error {
+	_, err := m.db.Exec(`update incidents set alerted = false where id = $1`, incidentId)
+	return err
+}
+
+func (m *Model) AlertedIncident(incidentId int64) error {
+	_, err := m.db.Exec(`update incidents set alerted = true where id = $1`, incidentId)
+	return err
+}
+
+// OpenIncidentFor returns the site's open incident, or nil
+func (m *Model) OpenIncidentFor(siteId int64) (*Incident, error) {
+	incident, err := scanIncident(m.db.QueryRow(
+		`select `+incidentColumns+` from incidents where site_id = $1 and resolved_at is null`,
+		siteId,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Heartbeat sites are pushed to instead of checked. A job requests the
+// site's url, /hb/<token>, when it succeeds, /hb/<token>/start when it
+// starts and /hb/<token>/fail when it fails. The site goes down when no ping
+// arrives within its check interval plus its grace period.
+
+const heartbeatPrefix = "/hb/"
+
+// maxHeartbeatLog is how much of a heartbeat's request body is kept
+const maxHeartbeatLog = 10 * 1024
+
+func newHeartbeatUrl() string {
+	return heartbeatPrefix + randomHex(16)
+}
+
+// baseUrl is where this app is reached, for showing heartbeat urls in full
+func baseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s Site) GraceDuration() time.Duration {
+	return time.Duration(s.Grace) * time.Second
+}
+
+// heartbeat records pings pushed by jobs, it is public and takes any method
+// so jobs can use curl, wget or a HEAD request
+func (app *App) heartbeat(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, heartbeatPrefix)
+	token, action, _ := strings.Cut(token, "/")
+	site, err := app.model.FindHeartbeatSite(token)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if site == nil || (action != "" && action != "start" && action != "fail") {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if site.PausedAt.Valid {
+		// the job shouldn't fail because its monitor is paused
+		fmt.Fprintln(w, "OK, paused")
+		return
+	}
+
+	now := time.Now()
+	if action == "start" {
+		err = app.model.StartHeartbeat(site.Id, sql.NullInt64{Int64: now.Unix(), Valid: true})
+		if err != nil {
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHeartbeatLog))
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+	ping := Ping{
+		SiteId:    site.Id,
+		Up:        action == "",
+		Log:       nullify(string(body)),
+		CheckedAt: now.Unix(),
+	}
+	if site.HeartbeatStartedAt.Valid {
+		// latency of a heartbeat is how long the job ran
+		ping.Latency = now.Sub(time.Unix(site.HeartbeatStartedAt.Int64, 0)).Milliseconds()
+	}
+	if action == "fail" {
+		ping.ErrorKind = nullify(ErrorHeartbeat)
+		ping.Error = nullify("job reported a failure")
+	}
+	app.worker.record(*site, ping)
+	// the run is over, the next ping without a start has no latency
+	err = app.model.StartHeartbeat(site.Id, sql.NullInt64{})
+	if err != nil {
+		app.logger.Printf("message=Could not clear heartbeat start site_id=%d error=%q", site.Id, err)
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// checkHeartbeat records a missed heartbeat once the site's deadline has
+// passed and returns how long to wait before looking again
+func (this Worker) checkHeartbeat(site Site) time.Duration {
+	last, err := this.model.LastCheckedAt(site.Id)
+	if err != nil {
+		this.logger.Printf("message=Could not find last heartbeat site_id=%d error=%q", site.Id, err)
+		return site.Interval()
+	}
+	since := site.CreatedAt
+	if last.Valid {
+		since = last.Int64
+	}
+	deadline := time.Unix(since, 0).Add(site.Interval() + site.GraceDuration())
+	if wait := time.Until(deadline); wait > 0 {
+		return wait + time.Second
+	}
+	this.record(site, Ping{
+		SiteId:    site.Id,
+		ErrorKind: nullify(ErrorHeartbeat),
+		Error:     nullify(fmt.Sprintf("no ping within %v and %v grace", site.Interval(), site.GraceDuration())),
+		CheckedAt: time.Now().Unix(),
+	})
+	return site.Interval() + site.GraceDuration()
+}
+
+// FindHeartbeatSite returns the heartbeat site with the given token, or nil
+func (m *Model) FindHeartbeatSite(token string) (*Site, error) {
+	site := Site{}
+	err := scanSite(m.db.QueryRow(
+		`
+		select `+siteColumns+`
+		from sites
+		where check_type = $1 and url = $2
+		`,
+		CheckHeartbeat, heartbeatPrefix+token,
+	), &site)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+func (m *Model) StartHeartbeat(siteId int64, startedAt sql.NullInt64) error {
+	_, err := m.db.Exec(`update sites set heartbeat_started_at = $1 where id = $2`, startedAt, siteId)
+	return err
+}
+
+func (m *Model) LastCheckedAt(siteId int64) (sql.NullInt64, error) {
+	var checkedAt sql.NullInt64
+	err := scan(m.db.QueryRow(`select max(checked_at) from pings where site_id = $1`, siteId), &checkedAt)
+	return checkedAt, err
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JsonAssertion is one line of a json check's assertions, a path into the
+// response followed by an operator and, except for exists, a JSON value:
+//
+//	$.status == "ok"
+//	$.checks[0].latency_ms < 500
+//	$["db"] exists
+type JsonAssertion struct {
+	Source string
+	Path   []interface{} // string keys and int indexes
+	Op     string
+	Value  interface{}
+}
+
+var jsonOps = []string{"==", "!=", "<=", ">=", "<", ">", "exists"}
+
+// ParseJsonAssertions reads assertions written one per line, blank lines are
+// skipped
+func ParseJsonAssertions(s string) ([]JsonAssertion, error) {
+	var assertions []JsonAssertion
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		assertion, err := parseJsonAssertion(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", line, err)
+		}
+		assertions = append(assertions, assertion)
+	}
+	return assertions, nil
+}
+
+func parseJsonAssertion(line string) (JsonAssertion, error) {
+	assertion := JsonAssertion{Source: line}
+	path, rest, err := parseJsonPath(line)
+	if err != nil {
+		return assertion, err
+	}
+	assertion.Path = path
+	rest = strings.TrimSpace(rest)
+	for _, op := range jsonOps {
+		if strings.HasPrefix(rest, op) {
+			assertion.Op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	switch {
+	case assertion.Op == "":
+		return assertion, errors.New("expected one of == != < <= > >= exists after the path")
+	case assertion.Op == "exists":
+		if rest != "" {
+			return assertion, errors.New("exists doesn't take a value")
+		}
+		return assertion, nil
+	}
+	err = json.Unmarshal([]byte(rest), &assertion.Value)
+	if err != nil {
+		return assertion, fmt.Errorf("invalid value %q, strings need double quotes", rest)
+	}
+	if _, ok := assertion.Value.(float64); !ok && assertion.Op != "==" && assertion.Op != "!=" {
+		return assertion, fmt.Errorf("%s needs a number", assertion.Op)
+	}
+	return assertion, nil
+}
+
+// parseJsonPath reads a path like $.a.b[0]["c d"] from the start of s and
+// returns what follows it
+func parseJsonPath(s string) ([]interface{}, string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, s, errors.New("path must start with $")
+	}
+	s = s[1:]
+	path := []interface{}{}
+	for {
+		switch {
+		case strings.HasPrefix(s, "."):
+			end := 1
+			for end < len(s) && isPathChar(s[end]) {
+				end++
+			}
+			if end == 1 {
+				return nil, s, errors.New("expected a name after .")
+			}
+			path = append(path, s[1:end])
+			s = s[end:]
+		case strings.HasPrefix(s, `["`):
+			key, err := strconv.QuotedPrefix(s[1:])
+			if err != nil || !strings.HasPrefix(s[1+len(key):], "]") {
+				return nil, s, errors.New(`expected ["name"]`)
+			}
+			unquoted, _ := strconv.Unquote(key)
+			path = append(path, unquoted)
+			s = s[2+len(key):]
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, s, errors.New("expected [index]")
+			}
+			index, err := strconv.Atoi(s[1:end])
+			if err != nil || index < 0 {
+				return nil, s, errors.New("expected [index]")
+			}
+			path = append(path, index)
+			s = s[end+1:]
+		default:
+			return path, s, nil
+		}
+	}
+}
+
+func isPathChar(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// lookup follows the path into a decoded JSON document
+func (a JsonAssertion) lookup(doc interface{}) (interface{}, bool) {
+	current := doc
+	for _, step := range a.Path {
+		switch step := step.(type) {
+		case string:
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = object[step]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := current.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+			current = array[step]
+		}
+	}
+	return current, true
+}
+
+// Check describes how the assertion fails against the document, or returns
+// "" when it holds
+func (a JsonAssertion) Check(doc interface{}) string {
+	actual, ok := a.lookup(doc)
+	if !ok {
+		return fmt.Sprintf("%s failed, path not found", a.Source)
+	}
+	if a.Op == "exists" {
+		return ""
+	}
+	holds := false
+	switch a.Op {
+	case "==":
+		holds = reflect.DeepEqual(actual, a.Value)
+	case "!=":
+		holds = !reflect.DeepEqual(actual, a.Value)
+	default:
+		number, isNumber := actual.(float64)
+		value := a.Value.(float64)
+		switch {
+		case !isNumber:
+		case a.Op == "<":
+			holds = number < value
+		case a.Op == "<=":
+			holds = number <= value
+		case a.Op == ">":
+			holds = number > value
+		case a.Op == ">=":
+			holds = number >= value
+		}
+	}
+	if holds {
+		return ""
+	}
+	got, _ := json.Marshal(actual)
+	return fmt.Sprintf("%s failed, got %s", a.Source, got)
+}
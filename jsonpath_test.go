@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJsonPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		path []interface{}
+		rest string
+		err  bool
+	}{
+		{"$", []interface{}{}, "", false},
+		{"$.status == 1", []interface{}{"status"}, " == 1", false},
+		{"$.a.b_c-d[2].e", []interface{}{"a", "b_c-d", 2, "e"}, "", false},
+		{`$["db name"].ok`, []interface{}{"db name", "ok"}, "", false},
+		{`$["a\"]b"]`, []interface{}{`a"]b`}, "", false},
+		{"status", nil, "", true},
+		{"$.", nil, "", true},
+		{"$[-1]", nil, "", true},
+		{"$[x]", nil, "", true},
+		{"$[0", nil, "", true},
+		{`$["a"`, nil, "", true},
+	}
+	for _, test := range tests {
+		path, rest, err := parseJsonPath(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("parseJsonPath(%q) error = %v, want error %v", test.in, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if !reflect.DeepEqual(path, test.path) || rest != test.rest {
+			t.Errorf("parseJsonPath(%q) = %#v, %q, want %#v, %q", test.in, path, rest, test.path, test.rest)
+		}
+	}
+}
+
+func TestParseJsonAssertion(t *testing.T) {
+	tests := []struct {
+		in  string
+		op  string
+		err bool
+	}{
+		{`$.status == "ok"`, "==", false},
+		{`$.n <= 5`, "<=", false},
+		{`$.n < 5`, "<", false},
+		{`$.n >= 5`, ">=", false},
+		{`$.ok != true`, "!=", false},
+		{`$.db exists`, "exists", false},
+		{`$.db exists 1`, "", true},
+		{`$.status == ok`, "", true},
+		{`$.status < "ok"`, "", true},
+		{`$.status`, "", true},
+	}
+	for _, test := range tests {
+		assertion, err := parseJsonAssertion(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("parseJsonAssertion(%q) error = %v, want error %v", test.in, err, test.err)
+			continue
+		}
+		if !test.err && assertion.Op != test.op {
+			t.Errorf("parseJsonAssertion(%q) op = %q, want %q", test.in, assertion.Op, test.op)
+		}
+	}
+}
+
+func TestJsonAssertionCheck(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{
+		"status": "ok",
+		"version": "1.2",
+		"queue": {"depth": 12},
+		"checks": [{"latency_ms": 250}],
+		"db name": null,
+		"ok": true
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in    string
+		holds bool
+	}{
+		{`$.status == "ok"`, true},
+		{`$.status != "ok"`, false},
+		{`$.queue.depth < 100`, true},
+		{`$.queue.depth >= 12`, true},
+		{`$.queue.depth > 12`, false},
+		{`$.queue.depth == 12`, true},
+		{`$.checks[0].latency_ms <= 250`, true},
+		{`$.checks[1].latency_ms < 500`, false},
+		{`$["db name"] exists`, true},
+		{`$["db name"] == null`, true},
+		{`$.missing exists`, false},
+		{`$.ok == true`, true},
+		// a string that looks like a number isn't one
+		{`$.version > 1`, false},
+		{`$.version == 1.2`, false},
+		{`$.queue == {"depth": 12}`, true},
+		{`$.status.inner exists`, false},
+	}
+	for _, test := range tests {
+		assertion, err := parseJsonAssertion(test.in)
+		if err != nil {
+			t.Fatalf("parseJsonAssertion(%q): %v", test.in, err)
+		}
+		failed := assertion.Check(doc)
+		if (failed == "") != test.holds {
+			t.Errorf("%s holds = %v, want %v (%s)", test.in, failed == "", test.holds, failed)
+		}
+	}
+}
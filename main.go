@@ -8,13 +8,20 @@ import (
 )
 
 func main() {
-	db, err := sql.Open("sqlite3", "allyouruptime.sqlite3")
+	config := NewConfig()
+	// checks write from many goroutines, wait for the lock instead of failing
+	db, err := sql.Open("sqlite3", "allyouruptime.sqlite3?_busy_timeout=5000&_foreign_keys=on")
 	haltOn(err)
 	model, err := NewModel(db)
 	haltOn(err)
-	worker := NewWorker(log.Default(), model)
+	worker := NewWorker(log.Default(), model, config)
 	go worker.Work()
-	app, err := NewApp(log.Default(), model)
+	if config.SmtpHost == "" {
+		log.Printf("message=SMTP_HOST is not set, email alerts are off")
+	}
+	outbox := NewOutbox(log.Default(), model, config)
+	go outbox.Run()
+	app, err := NewApp(log.Default(), model, worker)
 	haltOn(err)
 	fmt.Println("Server is listening on port 9001")
 	http.ListenAndServe("localhost:9001", app)
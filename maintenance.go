@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance windows cover one site or all of a user's sites. Sites are
+// still checked during a window but the pings are marked as maintenance, they
+// don't change the site's status, open incidents, alert or count towards
+// uptime. A window happens once or recurs on a cron schedule.
+
+const (
+	maxMaintenanceMinutes = 7 * 24 * 60
+	// maintenanceAhead is how far ahead the dashboard shows windows
+	maintenanceAhead = 7 * 24 * time.Hour
+	// datetimeLocal is how datetime-local inputs post their value
+	datetimeLocal = "2006-01-02T15:04"
+)
+
+type MaintenanceWindow struct {
+	Id              int64
+	UserId          int64
+	SiteId          sql.NullInt64 // null covers all of the user's sites
+	Name            string
+	StartsAt        sql.NullInt64 // when a one-off window starts
+	Schedule        string        // a cron expression for recurring windows
+	DurationMinutes int
+	CreatedAt       int64
+	SiteTitle       sql.NullString
+	// NextStart and NextEnd are the active or next occurrence and Ongoing
+	// whether it has started, for the dashboard
+	NextStart int64
+	NextEnd   int64
+	Ongoing   bool
+}
+
+func (w MaintenanceWindow) Title() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return "Maintenance"
+}
+
+func (w MaintenanceWindow) Duration() time.Duration {
+	return time.Duration(w.DurationMinutes) * time.Minute
+}
+
+func (w MaintenanceWindow) Covers(site Site) bool {
+	return w.UserId == site.UserId && (!w.SiteId.Valid || w.SiteId.Int64 == site.Id)
+}
+
+// Occurrence is the window's occurrence that is active at now or the next
+// one, both times are zero once the window is over for good
+func (w MaintenanceWindow) Occurrence(now time.Time) (time.Time, time.Time) {
+	if w.Schedule == "" {
+		start := time.Unix(w.StartsAt.Int64, 0).UTC()
+		if !start.Add(w.Duration()).After(now) {
+			return time.Time{}, time.Time{}
+		}
+		return start, start.Add(w.Duration())
+	}
+	schedule, err := ParseSchedule(w.Schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}
+	}
+	// the first start after now - duration is the one still running at now
+	// or the next one
+	start := schedule.Next(now.Add(-w.Duration()).Add(time.Second))
+	if start.IsZero() {
+		return start, start
+	}
+	return start, start.Add(w.Duration())
+}
+
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	start, _ := w.Occurrence(now)
+	return !start.IsZero() && !start.After(now)
+}
+
+// upcomingMaintenance are the windows that are active or start within
+// maintenanceAhead, soonest first
+func upcomingMaintenance(windows []MaintenanceWindow, now time.Time) []MaintenanceWindow {
+	var upcoming []MaintenanceWindow
+	for _, w := range windows {
+		start, end := w.Occurrence(now)
+		if start.IsZero() || start.After(now.Add(maintenanceAhead)) {
+			continue
+		}
+		w.NextStart, w.NextEnd = start.Unix(), end.Unix()
+		w.Ongoing = !start.After(now)
+		upcoming = append(upcoming, w)
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].NextStart < upcoming[j].NextStart
+	})
+	return upcoming
+}
+
+// inMaintenance reports whether one of the user's windows covering the site
+// is active
+func (this Worker) inMaintenance(site Site) bool {
+	windows, err := this.model.SiteMaintenance(site)
+	if err != nil {
+		this.logger.Printf("message=Could not find maintenance windows site_id=%d error=%q", site.Id, err)
+		return false
+	}
+	now := time.Now()
+	for _, w := range windows {
+		if w.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+type Maintenance struct {
+	Windows         []MaintenanceWindow
+	Sites           []Site
+	Name            string
+	SiteId          string
+	Recurring       bool
+	StartsAt        string
+	Schedule        string
+	Duration        string
+	InvalidSite     bool
+	InvalidStartsAt bool
+	ScheduleError   string
+	InvalidDuration bool
+}
+
+func (m Maintenance) Valid() bool {
+	return !m.InvalidSite && !m.InvalidStartsAt && m.ScheduleError == "" && !m.InvalidDuration
+}
+
+func (app *App) maintenance(w http.ResponseWriter, r *http.Request) {
+	app.renderMaintenance(w, r, Maintenance{Duration: "60"})
+}
+
+func (app *App) renderMaintenance(w http.ResponseWriter, r *http.Request, form Maintenance) {
+	userId := app.currentUserId(r)
+	windows, err := app.model.ListMaintenance(userId)
+	haltOn(err)
+	form.Windows = windows
+	form.Sites = app.model.ListSites(userId)
+	successFlash, err := GetFlash(w, r, "success")
+	haltOn(err)
+	errorFlash, err := GetFlash(w, r, "error")
+	haltOn(err)
+	app.render(w, r, "maintenance", View{SuccessFlash: string(successFlash), ErrorFlash: string(errorFlash), Maintenance: form})
+}
+
+func (app *App) createMaintenance(w http.ResponseWriter, r *http.Request) {
+	form := Maintenance{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		SiteId:    r.FormValue("site_id"),
+		Recurring: r.FormValue("kind") == "recurring",
+		StartsAt:  strings.TrimSpace(r.FormValue("starts_at")),
+		Schedule:  strings.TrimSpace(r.FormValue("schedule")),
+		Duration:  strings.TrimSpace(r.FormValue("duration")),
+	}
+	window := MaintenanceWindow{UserId: app.currentUserId(r), Name: form.Name}
+	if form.SiteId != "" {
+		siteId, err := strconv.ParseInt(form.SiteId, 10, 64)
+		form.InvalidSite = err != nil
+		window.SiteId = sql.NullInt64{Int64: siteId, Valid: true}
+	}
+	duration, err := strconv.Atoi(form.Duration)
+	form.InvalidDuration = err != nil || duration < 1 || duration > maxMaintenanceMinutes
+	window.DurationMinutes = duration
+	if form.Recurring {
+		schedule, err := ParseSchedule(form.Schedule)
+		if err != nil {
+			form.ScheduleError = err.Error()
+		} else if schedule.Next(time.Now()).IsZero() {
+			form.ScheduleError = "the schedule never runs"
+		}
+		window.Schedule = form.Schedule
+	} else {
+		startsAt, err := time.ParseInLocation(datetimeLocal, form.StartsAt, time.UTC)
+		form.InvalidStartsAt = err != nil || !startsAt.Add(window.Duration()).After(time.Now())
+		window.StartsAt = sql.NullInt64{Int64: startsAt.Unix(), Valid: true}
+	}
+	if !form.Valid() {
+		app.renderMaintenance(w, r, form)
+		return
+	}
+	created, err := app.model.CreateMaintenance(window)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		form.InvalidSite = true
+		app.renderMaintenance(w, r, form)
+		return
+	}
+	SetFlash(w, "success", []byte("Maintenance window added"))
+	redirect(w, r, "/maintenance")
+}
+
+func (app *App) deleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	_, err := app.model.DeleteMaintenance(app.currentUserId(r), r.FormValue("id"))
+	if err != nil {
+		SetFlash(w, "error", []byte("Could not delete maintenance window"))
+	}
+	redirect(w, r, "/maintenance")
+}
+
+const maintenanceColumns = `maintenance_windows.id, maintenance_windows.user_id, maintenance_windows.site_id,
+	maintenance_windows.name, maintenance_windows.starts_at, maintenance_windows.schedule,
+	maintenance_windows.duration_minutes, maintenance_windows.created_at`
+
+func (m *Model) queryMaintenance(query string, args ...interface{}) ([]MaintenanceWindow, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var windows []MaintenanceWindow
+	for rows.Next() {
+		w := MaintenanceWindow{}
+		err = rows.Scan(
+			&w.Id, &w.UserId, &w.SiteId, &w.Name, &w.StartsAt, &w.Schedule, &w.DurationMinutes, &w.CreatedAt,
+			&w.SiteTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+// CreateMaintenance reports false when the site isn't the user's
+func (m *Model) CreateMaintenance(w MaintenanceWindow) (bool, error) {
+	result, err := m.db.Exec(
+		`
+		insert into maintenance_windows (user_id, site_id, name, starts_at, schedule, duration_minutes)
+		select $1, $2, $3, $4, $5, $6
+		where $2 is null or exists (select 1 from sites where id = $2 and user_id = $1)
+		`,
+		w.UserId, w.SiteId, w.Name, w.StartsAt, w.Schedule, w.DurationMinutes,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (m *Model) ListMaintenance(userId int64) ([]MaintenanceWindow, error) {
+	return m.queryMaintenance(
+		`
+		select `+maintenanceColumns+`, coalesce(sites.name, sites.url)
+		from maintenance_windows
+		left outer join sites on sites.id = maintenance_windows.site_id
+		where maintenance_windows.user_id = $1
+		order by maintenance_windows.id
+		`,
+		userId,
+	)
+}
+
+// SiteMaintenance are the windows covering a site
+func (m *Model) SiteMaintenance(site Site) ([]MaintenanceWindow, error) {
+	return m.queryMaintenance(
+		`
+		select `+maintenanceColumns+`, null
+		from maintenance_windows
+		where user_id = $1 and (site_id is null or site_id = $2)
+		`,
+		site.UserId, site.Id,
+	)
+}
+
+func (m *Model) DeleteMaintenance(userId int64, id string) (sql.Result, error) {
+	return m.db.Exec(`delete from maintenance_windows where id = $1 and user_id = $2`, id, userId)
+}
@@ -29,21 +29,201 @@ type User struct {
 }
 
 type Site struct {
+	Id                 int64
+	UserId             int64
+	Name               sql.NullString
+	Url                string
+	CheckType          string
+	CheckInterval      int // seconds
+	Timeout            int // seconds
+	ConfirmFailures    int
+	RetryDelay         int // seconds
+	Method             string
+	Headers            string // one "Name: value" per line
+	Body               string
+	ExpectedStatus     string // e.g. "200-299,301"
+	BodyContains       string
+	BodyNotContains    string
+	BodyRegex          string
+	JsonAssertions     string // one JsonAssertion per line
+	DnsResolver        string // address:port, blank for the system resolver
+	DnsRecordType      string
+	DnsExpected        string // one value per line
+	Grace              int    // seconds a heartbeat may be late
+	HeartbeatStartedAt sql.NullInt64
+	EscalationPolicyId sql.NullInt64
+	FlappingSince      sql.NullInt64 // alerts are paused while the site flaps
+	PausedAt           sql.NullInt64 // paused sites aren't checked
+	ResumeAt           sql.NullInt64 // when a paused site resumes by itself
+	Status             sql.NullString
+	StatusChangedAt    sql.NullInt64
+	CertExpiresAt      sql.NullInt64
+	CertIssuer         sql.NullString
+	CertSans           sql.NullString
+	CertValid          sql.NullBool
+	CertError          sql.NullString
+	CertCheckedAt      sql.NullInt64
+	CertWarnedDays     sql.NullInt64 // the warning threshold the certificate has crossed
+	LastStatusCode     sql.NullInt64
+	LastLatency        sql.NullInt64
+	LastError          sql.NullString
+	LastErrorKind      sql.NullString
+	LastCheckedAt      sql.NullInt64
+	LastAttempt        sql.NullInt64
+	LastPending        sql.NullBool
+	LastLog            sql.NullString
+	LastDowntime       sql.NullInt64
+	LastRecovery       sql.NullInt64
+	LastIncidentId     sql.NullInt64
+	LastAcknowledgedAt sql.NullInt64
+	MaintenanceUntil   sql.NullInt64 // set on the dashboard during a maintenance window
+	Uptime             Uptime
+	UpdatedAt          sql.NullInt64
+	CreatedAt          int64
+}
+
+// Check types, how the worker checks a site
+const (
+	CheckHttp      = "http"
+	CheckJson      = "json"
+	CheckTcp       = "tcp"
+	CheckDns       = "dns"
+	CheckHeartbeat = "heartbeat"
+)
+
+const (
+	MinCheckInterval = 30
+	MaxCheckInterval = 60 * 60
+	// jobs pushing heartbeats often run daily or weekly
+	MaxHeartbeatInterval = 7 * 24 * 60 * 60
+	MaxGrace             = 24 * 60 * 60
+	MaxTimeout           = 60
+	MaxConfirmations     = 10
+	MaxRetryDelay        = 5 * 60
+)
+
+// Title is how a site is named in alerts
+func (s Site) Title() string {
+	if s.Name.Valid {
+		return s.Name.String
+	}
+	return s.Url
+}
+
+func (s Site) Interval() time.Duration {
+	return time.Duration(s.CheckInterval) * time.Second
+}
+
+func (s Site) TimeoutDuration() time.Duration {
+	return time.Duration(s.Timeout) * time.Second
+}
+
+func (s Site) RetryDelayDuration() time.Duration {
+	return time.Duration(s.RetryDelay) * time.Second
+}
+
+// LastReason describes why the latest check failed
+func (s Site) LastReason() string {
+	return reason(s.LastErrorKind, s.LastError, int(s.LastStatusCode.Int64))
+}
+
+// LastDowntimeDuration is how long the site's latest incident lasted, or has
+// lasted so far when the site is still down.
+func (s Site) LastDowntimeDuration() time.Duration {
+	end := time.Now().Unix()
+	if s.LastRecovery.Valid {
+		end = s.LastRecovery.Int64
+	}
+	return time.Duration(end-s.LastDowntime.Int64) * time.Second
+}
+
+// Ping is the result of a single check of a site. Every check is stored,
+// StatusCode is 0 when no response was received and Error holds the reason.
+//
+// Attempt counts consecutive failures, it is 0 for a ping that succeeded. A
+// failure is Pending until the site's ConfirmFailures is reached, pending
+// pings are kept for diagnostics but don't change the site's status.
+type Ping struct {
+	Id          int64
+	SiteId      int64
+	StatusCode  int
+	Up          bool
+	Latency     int64 // milliseconds
+	Error       sql.NullString
+	ErrorKind   sql.NullString
+	Attempt     int
+	Pending     bool
+	Log         sql.NullString // what a job sent with its heartbeat
+	Maintenance bool           // checked during a maintenance window
+	CheckedAt   int64
+	UpdatedAt   sql.NullInt64
+	CreatedAt   int64
+	Cert        *Cert // seen by https checks, stored on the site
+}
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+func (p Ping) Reason() string {
+	return reason(p.ErrorKind, p.Error, p.StatusCode)
+}
+
+func (p Ping) Status() string {
+	if p.Up {
+		return StatusUp
+	}
+	return StatusDown
+}
+
+// Incident is an outage of a site, opened by the first failing ping and
+// resolved by the first ping after it that succeeds.
+type Incident struct {
 	Id             int64
-	UserId         int64
-	Name           sql.NullString
-	Url            string
-	LastStatusCode sql.NullInt64
-	LastDowntime   sql.NullInt64
+	SiteId         int64
+	StartedAt      int64
+	ResolvedAt     sql.NullInt64
+	StatusCode     int
+	Error          sql.NullString
+	ErrorKind      sql.NullString
+	PingId         int64
+	RecoveryPingId sql.NullInt64
+	AckToken       sql.NullString // lets alert links acknowledge without logging in
+	AcknowledgedAt sql.NullInt64
+	EscalationStep int           // how many escalation steps have been notified
+	EscalateAt     sql.NullInt64 // when the next step is due
+	Alerted        bool          // false when it opened while the site was flapping
 	UpdatedAt      sql.NullInt64
 	CreatedAt      int64
 }
 
-type Ping struct {
+func (i Incident) Reason() string {
+	return reason(i.ErrorKind, i.Error, i.StatusCode)
+}
+
+func (i Incident) Open() bool {
+	return !i.ResolvedAt.Valid
+}
+
+// Duration is how long the incident lasted, or has lasted so far when it is
+// still open.
+func (i Incident) Duration() time.Duration {
+	end := time.Now().Unix()
+	if i.ResolvedAt.Valid {
+		end = i.ResolvedAt.Int64
+	}
+	return time.Duration(end-i.StartedAt) * time.Second
+}
+
+// Transition records a site moving from one status to another, it is
+// written only when a ping's status differs from the site's current status.
+type Transition struct {
 	Id         int64
 	SiteId     int64
-	StatusCode int
-	UpdatedAt  int64
+	PingId     int64
+	FromStatus sql.NullString
+	ToStatus   string
 	CreatedAt  int64
 }
 
@@ -91,11 +271,208 @@ func NewModel(db *sql.DB) (Model, error) {
 			updated_at integer,
 			created_at integer not null default(unixepoch())
 		);
+
+		create table if not exists transitions (
+			id integer primary key,
+			site_id integer not null references sites(id) on delete cascade,
+			ping_id integer not null references pings(id) on delete cascade,
+			from_status text,
+			to_status text not null,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists incidents (
+			id integer primary key,
+			site_id integer not null references sites(id) on delete cascade,
+			started_at integer not null,
+			resolved_at integer,
+			status_code integer not null default(0),
+			error text,
+			ping_id integer not null references pings(id) on delete cascade,
+			recovery_ping_id integer references pings(id) on delete set null,
+			updated_at integer,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists notification_channels (
+			id integer primary key,
+			user_id integer not null references users(id) on delete cascade,
+			kind text not null,
+			name text,
+			url text not null,
+			secret text not null default(''),
+			updated_at integer,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists site_channels (
+			site_id integer not null references sites(id) on delete cascade,
+			channel_id integer not null references notification_channels(id) on delete cascade,
+			created_at integer not null default(unixepoch()),
+			primary key (channel_id, site_id)
+		);
+
+		create table if not exists escalation_policies (
+			id integer primary key,
+			user_id integer not null references users(id) on delete cascade,
+			name text not null constraint name_not_blank check(length(name) > 0),
+			updated_at integer,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists escalation_steps (
+			id integer primary key,
+			policy_id integer not null references escalation_policies(id) on delete cascade,
+			position integer not null,
+			channel_id integer not null references notification_channels(id) on delete cascade,
+			delay_minutes integer not null,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists maintenance_windows (
+			id integer primary key,
+			user_id integer not null references users(id) on delete cascade,
+			site_id integer references sites(id) on delete cascade,
+			name text not null default(''),
+			starts_at integer,
+			schedule text not null default(''),
+			duration_minutes integer not null,
+			created_at integer not null default(unixepoch())
+		);
+
+		create table if not exists deliveries (
+			id integer primary key,
+			user_id integer not null references users(id) on delete cascade,
+			site_id integer references sites(id) on delete cascade,
+			channel text not null,
+			target text not null,
+			subject text not null default(''),
+			payload text not null,
+			attempts integer not null default(0),
+			next_attempt_at integer,
+			delivered_at integer,
+			last_error text,
+			updated_at integer,
+			created_at integer not null default(unixepoch())
+		);
+	`)
+	if err != nil {
+		return model, err
+	}
+
+	for _, c := range addedColumns {
+		err = model.addColumn(c.table, c.column, c.definition, c.backfill)
+		if err != nil {
+			return model, err
+		}
+	}
+
+	_, err = model.db.Exec(`
+		create index if not exists pings_site_id_checked_at on pings(site_id, checked_at);
+		create index if not exists transitions_site_id on transitions(site_id);
+		create index if not exists incidents_site_id on incidents(site_id);
+		create index if not exists sites_url on sites(url);
+		create index if not exists deliveries_next_attempt_at on deliveries(next_attempt_at);
+		create index if not exists deliveries_user_id on deliveries(user_id);
+		create index if not exists incidents_escalate_at on incidents(escalate_at);
+		create unique index if not exists incidents_ack_token on incidents(ack_token);
+		create index if not exists notification_channels_user_id on notification_channels(user_id);
+		create index if not exists maintenance_windows_user_id on maintenance_windows(user_id);
 	`)
 
 	return model, err
 }
 
+// addedColumns are columns added after their table was first created,
+// existing databases pick them up on boot.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+	backfill   string // runs once, right after the column is added
+}{
+	{"sites", "status", "text", ""},
+	{"sites", "status_changed_at", "integer", ""},
+	{"sites", "check_interval", "integer not null default(300)", ""},
+	{"sites", "timeout", "integer not null default(10)", ""},
+	{"sites", "confirm_failures", "integer not null default(2)", ""},
+	{"sites", "retry_delay", "integer not null default(10)", ""},
+	// sites added before these were configurable were checked with HEAD and
+	// counted as up for anything below 500
+	{"sites", "method", "text not null default('GET')", `update sites set method = 'HEAD'`},
+	{"sites", "headers", "text not null default('')", ""},
+	{"sites", "body", "text not null default('')", ""},
+	{"sites", "expected_status", "text not null default('200-399')", `update sites set expected_status = '100-499'`},
+	{"sites", "body_contains", "text not null default('')", ""},
+	{"sites", "body_not_contains", "text not null default('')", ""},
+	{"sites", "body_regex", "text not null default('')", ""},
+	{"sites", "check_type", "text not null default('http')", ""},
+	{"sites", "json_assertions", "text not null default('')", ""},
+	{"sites", "dns_resolver", "text not null default('')", ""},
+	{"sites", "dns_record_type", "text not null default('A')", ""},
+	{"sites", "dns_expected", "text not null default('')", ""},
+	{"sites", "grace", "integer not null default(300)", ""},
+	{"sites", "heartbeat_started_at", "integer", ""},
+	{"pings", "log", "text", ""},
+	{"sites", "escalation_policy_id", "integer references escalation_policies(id) on delete set null", ""},
+	{"incidents", "ack_token", "text", ""},
+	{"incidents", "acknowledged_at", "integer", ""},
+	{"incidents", "escalation_step", "integer not null default(0)", ""},
+	{"incidents", "escalate_at", "integer", ""},
+	{"deliveries", "channel_id", "integer references notification_channels(id) on delete cascade", ""},
+	{"deliveries", "response_code", "integer", ""},
+	{"sites", "flapping_since", "integer", ""},
+	{"incidents", "alerted", "integer not null default(1)", ""},
+	{"pings", "maintenance", "integer not null default(0)", ""},
+	{"sites", "paused_at", "integer", ""},
+	{"sites", "resume_at", "integer", ""},
+	{"pings", "paused", "integer not null default(0)", ""},
+	{"sites", "cert_expires_at", "integer", ""},
+	{"sites", "cert_issuer", "text", ""},
+	{"sites", "cert_sans", "text", ""},
+	{"sites", "cert_valid", "integer", ""},
+	{"sites", "cert_error", "text", ""},
+	{"sites", "cert_checked_at", "integer", ""},
+	{"sites", "cert_warned_days", "integer", ""},
+	{"pings", "up", "integer not null default(1)", `update pings set up = status_code < 500`},
+	{"pings", "latency", "integer not null default(0)", ""},
+	{"pings", "error", "text", ""},
+	// pings written before every check was recorded only stored status
+	// changes, their creation time is the closest thing to a probe time
+	{"pings", "checked_at", "integer not null default(0)", `update pings set checked_at = created_at`},
+	{"pings", "attempt", "integer not null default(0)", ""},
+	{"pings", "pending", "integer not null default(0)", ""},
+	{"pings", "error_kind", "text", ""},
+	{"incidents", "error_kind", "text", ""},
+}
+
+func (m *Model) addColumn(table string, column string, definition string, backfill string) error {
+	rows, err := m.db.Query(`select name from pragma_table_info($1)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	_, err = m.db.Exec(fmt.Sprintf(`alter table %s add column %s %s`, table, column, definition))
+	if err != nil || backfill == "" {
+		return err
+	}
+	_, err = m.db.Exec(backfill)
+	return err
+}
+
 func (m *Model) CreateUser() (User, error) {
 	row := m.db.QueryRow(
 		`insert into users (
@@ -143,36 +520,241 @@ func (m *Model) DeleteSite(userId int64, id string) (sql.Result, error) {
 	return m.db.Exec(`delete from sites where user_id = $1 and id = $2`, userId, id)
 }
 
-func (m *Model) CreateSite(userId int64, name string, url string) (sql.Result, error) {
+// UpdateSite changes the user's site, a new url forgets the old url's
+// certificate
+func (m *Model) UpdateSite(site Site) (sql.Result, error) {
 	return m.db.Exec(
-		`insert into sites (user_id, name, url) values ($1, $2, $3)`,
-		userId, nullify(name), url,
+		`
+		update sites set
+			name = $1, url = $2, check_type = $3, check_interval = $4, timeout = $5, confirm_failures = $6,
+			retry_delay = $7, method = $8, headers = $9, body = $10, expected_status = $11,
+			body_contains = $12, body_not_contains = $13, body_regex = $14, json_assertions = $15,
+			dns_resolver = $16, dns_record_type = $17, dns_expected = $18, grace = $19,
+			cert_expires_at = iif(url = $2, cert_expires_at, null),
+			cert_issuer = iif(url = $2, cert_issuer, null),
+			cert_sans = iif(url = $2, cert_sans, null),
+			cert_valid = iif(url = $2, cert_valid, null),
+			cert_error = iif(url = $2, cert_error, null),
+			cert_checked_at = iif(url = $2, cert_checked_at, null),
+			cert_warned_days = iif(url = $2, cert_warned_days, null),
+			updated_at = unixepoch()
+		where id = $20 and user_id = $21
+		`,
+		site.Name, site.Url, site.CheckType, site.CheckInterval, site.Timeout, site.ConfirmFailures,
+		site.RetryDelay, site.Method, site.Headers, site.Body, site.ExpectedStatus,
+		site.BodyContains, site.BodyNotContains, site.BodyRegex, site.JsonAssertions,
+		site.DnsResolver, site.DnsRecordType, site.DnsExpected, site.Grace,
+		site.Id, site.UserId,
 	)
 }
 
-func (m *Model) CreatePing(siteId int64, statusCode int) (sql.Result, error) {
-	row := m.db.QueryRow("select status_code from pings where site_id = ?", siteId)
-	var lastStatusCode int
-	err := row.Scan(&lastStatusCode)
-	if err != sql.ErrNoRows {
-		haltOn(err)
+func (m *Model) CreateSite(site Site) (sql.Result, error) {
+	return m.db.Exec(
+		`
+		insert into sites (
+			user_id, name, url, check_type, check_interval, timeout, confirm_failures, retry_delay,
+			method, headers, body, expected_status,
+			body_contains, body_not_contains, body_regex, json_assertions,
+			dns_resolver, dns_record_type, dns_expected, grace
+		) values (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20
+		)
+		`,
+		site.UserId, site.Name, site.Url, site.CheckType, site.CheckInterval, site.Timeout, site.ConfirmFailures, site.RetryDelay,
+		site.Method, site.Headers, site.Body, site.ExpectedStatus,
+		site.BodyContains, site.BodyNotContains, site.BodyRegex, site.JsonAssertions,
+		site.DnsResolver, site.DnsRecordType, site.DnsExpected, site.Grace,
+	)
+}
+
+const pingColumns = `pings.id, pings.site_id, pings.status_code, pings.up, pings.latency, pings.error,
+	pings.error_kind, pings.attempt, pings.pending, pings.log, pings.maintenance, pings.checked_at, pings.updated_at, pings.created_at`
+
+func scanPing(row interface{ Scan(...interface{}) error }) (Ping, error) {
+	ping := Ping{}
+	err := row.Scan(
+		&ping.Id, &ping.SiteId, &ping.StatusCode, &ping.Up, &ping.Latency, &ping.Error,
+		&ping.ErrorKind, &ping.Attempt, &ping.Pending, &ping.Log, &ping.Maintenance, &ping.CheckedAt, &ping.UpdatedAt, &ping.CreatedAt,
+	)
+	return ping, err
+}
+
+func (m *Model) CreatePing(ping Ping) (Ping, error) {
+	row := m.db.QueryRow(
+		`
+		insert into pings (
+			site_id,
+			status_code,
+			up,
+			latency,
+			error,
+			error_kind,
+			attempt,
+			pending,
+			log,
+			maintenance,
+			checked_at
+		) values (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		)
+		returning `+pingColumns,
+		ping.SiteId, ping.StatusCode, ping.Up, ping.Latency, ping.Error, ping.ErrorKind, ping.Attempt, ping.Pending,
+		ping.Log, ping.Maintenance, ping.CheckedAt,
+	)
+	return scanPing(row)
+}
+
+// NextAttempt numbers a failing ping of the site, 1 for the first failure
+// after a success and one more for each failure after that
+func (m *Model) NextAttempt(siteId int64) (int, error) {
+	var attempt int
+	err := scan(
+		m.db.QueryRow(`select attempt from pings where site_id = $1 order by id desc limit 1`, siteId),
+		&attempt,
+	)
+	return attempt + 1, err
+}
+
+// FirstFailure returns the ping that started the run of failures the given
+// ping belongs to
+func (m *Model) FirstFailure(ping Ping) (Ping, error) {
+	row := m.db.QueryRow(
+		`
+		select `+pingColumns+`
+		from pings
+		where site_id = $1 and id <= $2 and attempt = 1
+		order by id desc
+		limit 1
+		`,
+		ping.SiteId, ping.Id,
+	)
+	first, err := scanPing(row)
+	if err == sql.ErrNoRows {
+		return ping, nil
+	}
+	return first, err
+}
+
+// RecordStatus moves the ping's site to the ping's status. A transition is
+// returned only when the status changed, repeated results return nil.
+func (m *Model) RecordStatus(ping Ping) (*Transition, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+	err = tx.QueryRow(`select status from sites where id = $1`, ping.SiteId).Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+	status := ping.Status()
+	if current.Valid && current.String == status {
+		return nil, nil
+	}
+
+	_, err = tx.Exec(
+		`
+		update sites
+		set status = $1, status_changed_at = $2
+		where id = $3
+		`,
+		status, ping.CheckedAt, ping.SiteId,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	if lastStatusCode != statusCode {
-		return m.db.Exec(
-			`
-			insert into pings (
-				site_id,
-				status_code
-			) values (
-				$1, $2
-			)
-			`,
-			siteId, statusCode,
+	transition := Transition{}
+	err = tx.QueryRow(
+		`
+		insert into transitions (
+			site_id,
+			ping_id,
+			from_status,
+			to_status,
+			created_at
+		) values (
+			$1, $2, $3, $4, $5
 		)
-	} else {
+		returning id, site_id, ping_id, from_status, to_status, created_at
+		`,
+		ping.SiteId, ping.Id, current, status, ping.CheckedAt,
+	).Scan(&transition.Id, &transition.SiteId, &transition.PingId, &transition.FromStatus, &transition.ToStatus, &transition.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transition, tx.Commit()
+}
+
+const incidentColumns = `incidents.id, incidents.site_id, incidents.started_at, incidents.resolved_at,
+	incidents.status_code, incidents.error, incidents.error_kind, incidents.ping_id, incidents.recovery_ping_id,
+	incidents.ack_token, incidents.acknowledged_at, incidents.escalation_step, incidents.escalate_at,
+	incidents.alerted, incidents.updated_at, incidents.created_at`
+
+func scanIncident(row interface{ Scan(...interface{}) error }) (Incident, error) {
+	incident := Incident{}
+	err := row.Scan(
+		&incident.Id, &incident.SiteId, &incident.StartedAt, &incident.ResolvedAt,
+		&incident.StatusCode, &incident.Error, &incident.ErrorKind, &incident.PingId, &incident.RecoveryPingId,
+		&incident.AckToken, &incident.AcknowledgedAt, &incident.EscalationStep, &incident.EscalateAt,
+		&incident.Alerted, &incident.UpdatedAt, &incident.CreatedAt,
+	)
+	return incident, err
+}
+
+// OpenIncident starts an incident from the site's first failing ping. A site
+// has at most one open incident, nil is returned when one is already open.
+func (m *Model) OpenIncident(ping Ping) (*Incident, error) {
+	row := m.db.QueryRow(
+		`
+		insert into incidents (
+			site_id,
+			started_at,
+			status_code,
+			error,
+			error_kind,
+			ping_id,
+			ack_token
+		)
+		select $1, $2, $3, $4, $5, $6, $7
+		where not exists (
+			select 1 from incidents where site_id = $1 and resolved_at is null
+		)
+		returning `+incidentColumns,
+		ping.SiteId, ping.CheckedAt, ping.StatusCode, ping.Error, ping.ErrorKind, ping.Id, randomHex(16),
+	)
+	incident, err := scanIncident(row)
+	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// ResolveIncident closes the site's open incident with the recovering ping,
+// nil is returned when there is no open incident.
+func (m *Model) ResolveIncident(ping Ping) (*Incident, error) {
+	row := m.db.QueryRow(
+		`
+		update incidents
+		set resolved_at = $1, recovery_ping_id = $2, updated_at = unixepoch()
+		where site_id = $3 and resolved_at is null
+		returning `+incidentColumns,
+		ping.CheckedAt, ping.Id, ping.SiteId,
+	)
+	incident, err := scanIncident(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
 }
 
 func (m *Model) FindCurrentUser(sessionId string) *User {
@@ -256,29 +838,37 @@ func scan(row *sql.Row, values ...interface{}) error {
 func (m *Model) ListSites(userId int64) []Site {
 	rows, err := m.db.Query(
 		`
-		select sites.id, sites.user_id, sites.name, sites.url, pings.status_code, pings.created_at
+		select
+			`+siteColumns+`,
+			nullif(pings.status_code, 0), pings.latency, pings.error, pings.error_kind,
+			pings.checked_at, pings.attempt, pings.pending, pings.log,
+			incidents.started_at, incidents.resolved_at, incidents.id, incidents.acknowledged_at
 		from sites
-		left outer join (
-			select pings.site_id, pings.status_code, pings.created_at
-			from pings
-			where
-				id in (
-					select max(id) from pings group by site_id
-				)
-			and
-				status_code >= 500
-		) as pings
-		on sites.id = pings.site_id
+		left outer join pings
+		on pings.id = (
+			select max(id) from pings where pings.site_id = sites.id and not pings.paused
+		)
+		left outer join incidents
+		on incidents.id = (
+			select max(id) from incidents where incidents.site_id = sites.id
+		)
 		where sites.user_id = $1
 		`, userId,
 	)
 	haltOn(err)
 	defer rows.Close()
+	uptimes := m.Uptimes(userId)
 	var sites []Site
 	for rows.Next() {
 		site := Site{}
-		err = rows.Scan(&site.Id, &site.UserId, &site.Name, &site.Url, &site.LastStatusCode, &site.LastDowntime)
+		err = scanSite(
+			rows, &site,
+			&site.LastStatusCode, &site.LastLatency, &site.LastError, &site.LastErrorKind,
+			&site.LastCheckedAt, &site.LastAttempt, &site.LastPending, &site.LastLog,
+			&site.LastDowntime, &site.LastRecovery, &site.LastIncidentId, &site.LastAcknowledgedAt,
+		)
 		haltOn(err)
+		site.Uptime = uptimes[site.Id]
 		sites = append(sites, site)
 	}
 	if rows.Err() != nil {
@@ -292,6 +882,12 @@ func (m *Model) ListSites(userId int64) []Site {
 	return sites
 }
 
+func (m *Model) UserEmail(userId int64) (sql.NullString, error) {
+	var email sql.NullString
+	err := scan(m.db.QueryRow(`select email from users where id = $1`, userId), &email)
+	return email, err
+}
+
 func (m *Model) UpdateEmail(userId int64, e string) error {
 	email := nullify(e)
 	_, err := m.db.Exec(
@@ -320,8 +916,11 @@ func (m *Model) DeleteAccount(userId int64) error {
 
 func (m *Model) AllSites() []Site {
 	rows, err := m.db.Query(
-		`select id, user_id, name, url, updated_at, created_at
+		`select
+			` + siteColumns + `,
+			(select max(checked_at) from pings where pings.site_id = sites.id and not pings.paused)
 		from sites
+		where paused_at is null
 		order by created_at desc`,
 	)
 	haltOn(err)
@@ -337,17 +936,39 @@ func (m *Model) AllSites() []Site {
 	var sites []Site
 	for rows.Next() {
 		site := Site{}
-		err = rows.Scan(&site.Id, &site.UserId, &site.Name, &site.Url, &site.UpdatedAt, &site.CreatedAt)
+		err = scanSite(rows, &site, &site.LastCheckedAt)
 		haltOn(err)
 		sites = append(sites, site)
 	}
 	return sites
 }
 
-func newSite(row *sql.Row) (Site, error) {
-	site := Site{}
-	err := row.Scan(&site.Id, &site.UserId, &site.Name, &site.Url, &site.UpdatedAt, &site.CreatedAt)
-	return site, err
+const siteColumns = `sites.id, sites.user_id, sites.name, sites.url, sites.check_type, sites.check_interval, sites.timeout,
+	sites.confirm_failures, sites.retry_delay, sites.method, sites.headers, sites.body, sites.expected_status,
+	sites.body_contains, sites.body_not_contains, sites.body_regex, sites.json_assertions,
+	sites.dns_resolver, sites.dns_record_type, sites.dns_expected,
+	sites.grace, sites.heartbeat_started_at, sites.escalation_policy_id, sites.flapping_since,
+	sites.paused_at, sites.resume_at,
+	sites.status, sites.status_changed_at,
+	sites.cert_expires_at, sites.cert_issuer, sites.cert_sans, sites.cert_valid, sites.cert_error,
+	sites.cert_checked_at, sites.cert_warned_days,
+	sites.updated_at, sites.created_at`
+
+// scanSite scans siteColumns into site followed by any extra columns
+func scanSite(row interface{ Scan(...interface{}) error }, site *Site, extra ...interface{}) error {
+	dest := []interface{}{
+		&site.Id, &site.UserId, &site.Name, &site.Url, &site.CheckType, &site.CheckInterval, &site.Timeout,
+		&site.ConfirmFailures, &site.RetryDelay, &site.Method, &site.Headers, &site.Body, &site.ExpectedStatus,
+		&site.BodyContains, &site.BodyNotContains, &site.BodyRegex, &site.JsonAssertions,
+		&site.DnsResolver, &site.DnsRecordType, &site.DnsExpected,
+		&site.Grace, &site.HeartbeatStartedAt, &site.EscalationPolicyId, &site.FlappingSince,
+		&site.PausedAt, &site.ResumeAt,
+		&site.Status, &site.StatusChangedAt,
+		&site.CertExpiresAt, &site.CertIssuer, &site.CertSans, &site.CertValid, &site.CertError,
+		&site.CertCheckedAt, &site.CertWarnedDays,
+		&site.UpdatedAt, &site.CreatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
 }
 
 func (m *Model) passcode() string {
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A Notifier is a kind of notification channel. Adding a channel is writing
+// a Notifier and registering it under its kind, the worker, outbox and
+// channels page find it in the registry.
+type Notifier interface {
+	// Label names the kind in the UI
+	Label() string
+	// Configure fills in a channel from the url, or address, and key a user
+	// entered, it returns a message for the form when they are wrong
+	Configure(config Config, channel *Channel, url string, key string) string
+	// Format turns an alert into the message queued for the channel, it
+	// returns errSkipAlert for alerts the channel doesn't take
+	Format(config Config, channel Channel, alert Alert) (Message, error)
+	// Send delivers a queued message and returns the response code for
+	// channels that have one, an error retries the delivery
+	Send(config Config, channel Channel, delivery Delivery) (int, error)
+}
+
+// Message is a formatted alert, ready to be queued as a delivery
+type Message struct {
+	Target  string // shown in the delivery log
+	Subject string
+	Payload string
+}
+
+var errSkipAlert = errors.New("channel does not take this alert")
+
+var notifiers = map[string]Notifier{}
+
+// RegisterNotifier makes a kind of channel available, it is called from the
+// init of the file defining the notifier
+func RegisterNotifier(kind string, notifier Notifier) {
+	if _, ok := notifiers[kind]; ok {
+		panic(fmt.Sprintf("notifier %q registered twice", kind))
+	}
+	notifiers[kind] = notifier
+}
+
+// NotifierKinds are the registered kinds, sorted for the UI
+func NotifierKinds() []string {
+	var kinds []string
+	for kind := range notifiers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func findNotifier(kind string) (Notifier, error) {
+	notifier, ok := notifiers[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %q", kind)
+	}
+	return notifier, nil
+}
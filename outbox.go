@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Alerts are queued as deliveries and sent by the outbox, so a slow or
+// failing mail server or webhook never holds up a check. Failed deliveries
+// are retried with backoff until they succeed or run out of attempts.
+
+const (
+	outboxTick          = 5 * time.Second
+	outboxBatch         = 50
+	deliveryLogSize     = 50
+	maxDeliveryAttempts = 10
+	minDeliveryBackoff  = 30 * time.Second
+	maxDeliveryBackoff  = time.Hour
+)
+
+type Delivery struct {
+	Id            int64
+	UserId        int64
+	SiteId        sql.NullInt64
+	ChannelId     sql.NullInt64 // null for the profile email
+	Channel       string
+	Target        string // where it goes, an email address or url
+	Subject       string // the event for webhooks
+	Payload       string
+	Attempts      int
+	NextAttemptAt sql.NullInt64 // null once delivered or given up on
+	DeliveredAt   sql.NullInt64
+	LastError     sql.NullString
+	ResponseCode  sql.NullInt64
+	UpdatedAt     sql.NullInt64
+	CreatedAt     int64
+}
+
+// State is "delivered", "retrying" or "failed" once out of attempts
+func (d Delivery) State() string {
+	switch {
+	case d.DeliveredAt.Valid:
+		return "delivered"
+	case d.NextAttemptAt.Valid:
+		return "retrying"
+	default:
+		return "failed"
+	}
+}
+
+type Outbox struct {
+	logger Logger
+	model  Model
+	config Config
+}
+
+func NewOutbox(logger Logger, model Model, config Config) Outbox {
+	return Outbox{
+		logger: logger,
+		model:  model,
+		config: config,
+	}
+}
+
+// Run sends due deliveries forever. Deliveries are stored, so anything
+// queued before a restart is picked up again.
+func (this Outbox) Run() {
+	for {
+		deliveries, err := this.model.DueDeliveries(time.Now().Unix(), outboxBatch)
+		if err != nil {
+			this.logger.Printf("message=Could not find due deliveries error=%q", err)
+		}
+		for _, delivery := range deliveries {
+			this.deliver(delivery)
+		}
+		if len(deliveries) < outboxBatch {
+			time.Sleep(outboxTick)
+		}
+	}
+}
+
+func (this Outbox) deliver(delivery Delivery) {
+	code, err := this.send(delivery)
+	attempts := delivery.Attempts + 1
+	next := sql.NullInt64{}
+	if err != nil && attempts < maxDeliveryAttempts {
+		next = sql.NullInt64{Int64: time.Now().Add(deliveryBackoff(attempts)).Unix(), Valid: true}
+	}
+	updateErr := this.model.FinishDelivery(delivery.Id, code, err, next)
+	if updateErr != nil {
+		this.logger.Printf("message=Could not update delivery delivery_id=%d error=%q", delivery.Id, updateErr)
+	}
+	switch {
+	case err == nil:
+		this.logger.Printf("message=Delivered alert delivery_id=%d channel=%s", delivery.Id, delivery.Channel)
+	case next.Valid:
+		this.logger.Printf("message=Could not deliver alert, retrying delivery_id=%d channel=%s attempt=%d error=%q", delivery.Id, delivery.Channel, attempts, err)
+	default:
+		this.logger.Printf("message=Gave up delivering alert delivery_id=%d channel=%s attempts=%d error=%q", delivery.Id, delivery.Channel, attempts, err)
+	}
+}
+
+// send returns the response code for channels that have one
+func (this Outbox) send(delivery Delivery) (int, error) {
+	notifier, err := findNotifier(delivery.Channel)
+	if err != nil {
+		return 0, err
+	}
+	// the profile email isn't a stored channel
+	channel := Channel{UserId: delivery.UserId, Kind: delivery.Channel, Url: delivery.Target}
+	if delivery.ChannelId.Valid {
+		found, err := this.model.FindChannel(delivery.ChannelId.Int64)
+		if err != nil {
+			return 0, err
+		}
+		if found == nil {
+			return 0, fmt.Errorf("channel %d was deleted", delivery.ChannelId.Int64)
+		}
+		channel = *found
+	}
+	return notifier.Send(this.config, channel, delivery)
+}
+
+// deliveryBackoff doubles from 30 seconds up to an hour
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := minDeliveryBackoff
+	for i := 1; i < attempts && backoff < maxDeliveryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDeliveryBackoff {
+		return maxDeliveryBackoff
+	}
+	return backoff
+}
+
+const deliveryColumns = `
+	deliveries.id, deliveries.user_id, deliveries.site_id, deliveries.channel_id, deliveries.channel,
+	deliveries.target, deliveries.subject, deliveries.payload, deliveries.attempts,
+	deliveries.next_attempt_at, deliveries.delivered_at, deliveries.last_error, deliveries.response_code,
+	deliveries.updated_at, deliveries.created_at`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (Delivery, error) {
+	delivery := Delivery{}
+	err := row.Scan(
+		&delivery.Id, &delivery.UserId, &delivery.SiteId, &delivery.ChannelId, &delivery.Channel,
+		&delivery.Target, &delivery.Subject, &delivery.Payload, &delivery.Attempts,
+		&delivery.NextAttemptAt, &delivery.DeliveredAt, &delivery.LastError, &delivery.ResponseCode,
+		&delivery.UpdatedAt, &delivery.CreatedAt,
+	)
+	return delivery, err
+}
+
+// QueueDelivery stores a delivery to be sent straight away
+func (m *Model) QueueDelivery(delivery Delivery) (Delivery, error) {
+	return scanDelivery(m.db.QueryRow(
+		`
+		insert into deliveries (user_id, site_id, channel_id, channel, target, subject, payload, next_attempt_at)
+		values ($1, $2, $3, $4, $5, $6, $7, unixepoch())
+		returning `+deliveryColumns,
+		delivery.UserId, delivery.SiteId, delivery.ChannelId, delivery.Channel, delivery.Target, delivery.Subject,
+		delivery.Payload,
+	))
+}
+
+func (m *Model) DueDeliveries(now int64, limit int) ([]Delivery, error) {
+	return m.queryDeliveries(
+		`
+		select `+deliveryColumns+`
+		from deliveries
+		where next_attempt_at <= $1
+		-- a channel gets a site's alerts in order, so a resolve can't
+		-- overtake the trigger it resolves while that is being retried
+		and not exists (
+			select 1 from deliveries earlier
+			where earlier.channel_id = deliveries.channel_id
+			and earlier.site_id = deliveries.site_id
+			and earlier.id < deliveries.id
+			and earlier.next_attempt_at is not null
+		)
+		order by next_attempt_at
+		limit $2
+		`,
+		now, limit,
+	)
+}
+
+// ListDeliveries is the user's delivery log, newest first
+func (m *Model) ListDeliveries(userId int64, limit int) ([]Delivery, error) {
+	return m.queryDeliveries(
+		`
+		select `+deliveryColumns+`
+		from deliveries
+		where user_id = $1
+		order by id desc
+		limit $2
+		`,
+		userId, limit,
+	)
+}
+
+func (m *Model) queryDeliveries(query string, args ...interface{}) ([]Delivery, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []Delivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// FinishDelivery records an attempt, next is when to try again after an
+// error and null when there are no attempts left
+func (m *Model) FinishDelivery(id int64, code int, deliveryErr error, next sql.NullInt64) error {
+	lastError := sql.NullString{}
+	if deliveryErr != nil {
+		lastError = nullify(deliveryErr.Error())
+	}
+	_, err := m.db.Exec(
+		`
+		update deliveries
+		set attempts = attempts + 1,
+			last_error = $1,
+			next_attempt_at = $2,
+			delivered_at = case when $1 is null then unixepoch() end,
+			response_code = nullif($3, 0),
+			updated_at = unixepoch()
+		where id = $4
+		`,
+		lastError, next, code, id,
+	)
+	return err
+}
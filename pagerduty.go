@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+	"unicode/utf8"
+)
+
+// PagerDuty Events API v2, incidents trigger a page that resolves itself when
+// the site comes back up. Tests are sent as change events, which show on the
+// service without paging anyone. The channel's secret is its routing key.
+
+const KindPagerduty = "pagerduty"
+
+func init() {
+	RegisterNotifier(KindPagerduty, pagerdutyNotifier{})
+}
+
+type pagerdutyNotifier struct{}
+
+func (pagerdutyNotifier) Label() string {
+	return "PagerDuty"
+}
+
+func (pagerdutyNotifier) Configure(config Config, channel *Channel, url string, key string) string {
+	if key == "" {
+		return "Routing key can't be blank"
+	}
+	channel.Secret = key
+	return ""
+}
+
+func (pagerdutyNotifier) Format(config Config, channel Channel, alert Alert) (Message, error) {
+	// pages are for incidents, not certificates or flapping summaries
+	if alert.Event == AlertCert || alert.Event == AlertFlapping || alert.Event == AlertSettled {
+		return Message{}, errSkipAlert
+	}
+	payload, err := pagerdutyPayloadFor(config, channel, alert)
+	return Message{Target: pagerdutyUrl(config, alert.Event), Subject: alert.Event, Payload: string(payload)}, err
+}
+
+func (pagerdutyNotifier) Send(config Config, channel Channel, delivery Delivery) (int, error) {
+	// the events url is set by whoever runs the app, not by users
+	return postJson(&net.Dialer{Timeout: webhookTimeout}, pagerdutyUrl(config, delivery.Subject), []byte(delivery.Payload), nil)
+}
+
+// maxPagerdutySummary is the longest summary PagerDuty accepts
+const maxPagerdutySummary = 1024
+
+type pagerdutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action,omitempty"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerdutyPayload `json:"payload,omitempty"`
+	Links       []pagerdutyLink   `json:"links,omitempty"`
+}
+
+type pagerdutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity,omitempty"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pagerdutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// pagerdutyUrl is where an event is sent, tests go to the change events url
+func pagerdutyUrl(config Config, event string) string {
+	if event == AlertTest {
+		return config.PagerdutyUrl + "/v2/change/enqueue"
+	}
+	return config.PagerdutyUrl + "/v2/enqueue"
+}
+
+// pagerdutyDedupKey ties a resolve to the trigger for the same incident
+func pagerdutyDedupKey(alert Alert) string {
+	return fmt.Sprintf("allyouruptime-site-%d-incident-%d", alert.Site.Id, alert.Incident.Id)
+}
+
+func pagerdutyPayloadFor(config Config, channel Channel, alert Alert) ([]byte, error) {
+	event := pagerdutyEvent{RoutingKey: channel.Secret}
+	links := []pagerdutyLink{{Href: config.BaseUrl + "/", Text: "all your uptime"}}
+	summary := alert.Headline() + ": " + alert.Detail()
+	if len(summary) > maxPagerdutySummary {
+		// cut on a rune boundary so the payload stays valid utf-8
+		end := maxPagerdutySummary
+		for end > 0 && !utf8.RuneStart(summary[end]) {
+			end--
+		}
+		summary = summary[:end]
+	}
+	switch alert.Event {
+	case AlertTest:
+		// a trigger would open an incident nothing ever resolves
+		event.Payload = &pagerdutyPayload{
+			Summary:   summary,
+			Source:    alert.Site.Url,
+			Timestamp: time.Unix(alert.At(), 0).UTC().Format(time.RFC3339),
+		}
+		event.Links = links
+	case AlertDown:
+		event.EventAction = "trigger"
+		event.DedupKey = pagerdutyDedupKey(alert)
+		event.Payload = &pagerdutyPayload{
+			Summary:   summary,
+			Source:    alert.Site.Url,
+			Severity:  "critical",
+			Timestamp: time.Unix(alert.At(), 0).UTC().Format(time.RFC3339),
+			Component: alert.Site.Title(),
+			CustomDetails: map[string]string{
+				"reason":     alert.Incident.Reason(),
+				"error_kind": alert.Incident.ErrorKind.String,
+			},
+		}
+		event.Links = links
+		if alert.AckUrl != "" {
+			event.Links = append(event.Links, pagerdutyLink{Href: alert.AckUrl, Text: "Acknowledge"})
+		}
+	case AlertUp:
+		event.EventAction = "resolve"
+		event.DedupKey = pagerdutyDedupKey(alert)
+	default:
+		return nil, fmt.Errorf("pagerduty has no event for %s", alert.Event)
+	}
+	return json.Marshal(event)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A paused site isn't checked until it is resumed, by hand or at its
+// resume_at. Pausing and resuming each store a marker ping that doesn't count
+// towards uptime, it ends the last check's result at the pause and restarts a
+// heartbeat's deadline at the resume.
+
+func (app *App) pauseSite(w http.ResponseWriter, r *http.Request) {
+	var resumeAt sql.NullInt64
+	if value := strings.TrimSpace(r.FormValue("resume_at")); value != "" {
+		at, err := time.ParseInLocation(datetimeLocal, value, time.UTC)
+		if err != nil || !at.After(time.Now()) {
+			SetFlash(w, "error", []byte("Resume time must be in the future"))
+			redirect(w, r, "/")
+			return
+		}
+		resumeAt = sql.NullInt64{Int64: at.Unix(), Valid: true}
+	}
+	err := app.model.PauseSite(app.currentUserId(r), r.FormValue("id"), resumeAt)
+	if err == sql.ErrNoRows {
+		SetFlash(w, "error", []byte("Site not found or already paused"))
+	} else if err != nil {
+		SetFlash(w, "error", []byte("Could not pause site"))
+	} else {
+		SetFlash(w, "success", []byte("Site paused"))
+	}
+	redirect(w, r, "/")
+}
+
+func (app *App) resumeSite(w http.ResponseWriter, r *http.Request) {
+	err := app.model.ResumeSite(app.currentUserId(r), r.FormValue("id"))
+	if err == sql.ErrNoRows {
+		SetFlash(w, "error", []byte("Site not found or not paused"))
+	} else if err != nil {
+		SetFlash(w, "error", []byte("Could not resume site"))
+	} else {
+		SetFlash(w, "success", []byte("Site resumed"))
+	}
+	redirect(w, r, "/")
+}
+
+// sites resumes the sites whose resume time has come before listing the ones
+// to check
+func (this Worker) sites() []Site {
+	err := this.model.ResumeDue(time.Now().Unix())
+	if err != nil {
+		this.logger.Printf("message=Could not resume sites error=%q", err)
+	}
+	return this.model.AllSites()
+}
+
+func (m *Model) PauseSite(userId int64, id string, resumeAt sql.NullInt64) error {
+	return m.markPaused(
+		`
+		update sites set paused_at = unixepoch(), resume_at = $1
+		where id = $2 and user_id = $3 and paused_at is null
+		returning id
+		`,
+		resumeAt, id, userId,
+	)
+}
+
+func (m *Model) ResumeSite(userId int64, id string) error {
+	return m.markPaused(
+		`
+		update sites set paused_at = null, resume_at = null
+		where id = $1 and user_id = $2 and paused_at is not null
+		returning id
+		`,
+		id, userId,
+	)
+}
+
+// ResumeDue resumes the paused sites whose resume time has passed
+func (m *Model) ResumeDue(now int64) error {
+	rows, err := m.db.Query(`select id from sites where resume_at <= $1 and paused_at is not null`, now)
+	if err != nil {
+		return err
+	}
+	var siteIds []int64
+	for rows.Next() {
+		var siteId int64
+		err = rows.Scan(&siteId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		siteIds = append(siteIds, siteId)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	for _, siteId := range siteIds {
+		err = m.markPaused(
+			`
+			update sites set paused_at = null, resume_at = null
+			where id = $1 and paused_at is not null
+			returning id
+			`,
+			siteId,
+		)
+		// resumed by hand since the select
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return nil
+}
+
+// markPaused runs an update that pauses or resumes a site and stores the
+// marker ping, it returns sql.ErrNoRows when the update matches no site
+func (m *Model) markPaused(query string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var siteId int64
+	err = tx.QueryRow(query, args...).Scan(&siteId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`insert into pings (site_id, up, paused, checked_at) values ($1, false, true, unixepoch())`,
+		siteId,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
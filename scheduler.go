@@ -0,0 +1,231 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduler runs each site's check on the site's own interval using a fixed
+// pool of workers. Checks are spread across the interval with jitter so
+// sites added together don't fire together, and a site is never queued
+// again while its previous check is still running, so a slow target holds
+// at most one worker.
+type Scheduler struct {
+	logger  Logger
+	workers int
+	sites   func() []Site
+	check   func(Site) time.Duration
+	rnd     *rand.Rand
+
+	mu    sync.Mutex
+	stats SchedulerStats
+}
+
+// SchedulerStats describe the checks started since the last report. Lag is
+// how long a check waited past its due time for a free worker, a growing lag
+// or a queue that doesn't drain means the pool is undersized.
+type SchedulerStats struct {
+	Checks  int
+	LagSum  time.Duration
+	LagMax  time.Duration
+	Queued  int
+	Busy    int
+	Workers int
+}
+
+func (s SchedulerStats) LagAvg() time.Duration {
+	if s.Checks == 0 {
+		return 0
+	}
+	return s.LagSum / time.Duration(s.Checks)
+}
+
+type scheduled struct {
+	site    Site
+	due     time.Time
+	running bool
+}
+
+type job struct {
+	site Site
+	due  time.Time
+}
+
+// result is a finished check, retry is set when the site should be checked
+// again sooner than its interval
+type result struct {
+	id    int64
+	retry time.Duration
+}
+
+const (
+	schedulerTick    = 250 * time.Millisecond
+	schedulerRefresh = 15 * time.Second
+	schedulerReport  = 1 * time.Minute
+	// schedulerFirstCheck spreads the first checks of never checked sites
+	schedulerFirstCheck = 5 * time.Second
+)
+
+// NewScheduler returns a scheduler that checks the sites returned by sites.
+// check returns how soon to check a site again when it shouldn't wait for a
+// whole interval, or 0.
+func NewScheduler(logger Logger, workers int, sites func() []Site, check func(Site) time.Duration) *Scheduler {
+	return &Scheduler{
+		logger:  logger,
+		workers: workers,
+		sites:   sites,
+		check:   check,
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *Scheduler) Run() {
+	jobs := make(chan job)
+	done := make(chan result)
+	for i := 0; i < s.workers; i++ {
+		go s.work(jobs, done)
+	}
+
+	entries := map[int64]*scheduled{}
+	tick := time.NewTicker(schedulerTick)
+	report := time.NewTicker(schedulerReport)
+	var refreshed time.Time
+	for {
+		now := time.Now()
+		if now.Sub(refreshed) >= schedulerRefresh {
+			entries = s.refresh(entries, now)
+			refreshed = now
+		}
+		s.dispatch(entries, jobs, now)
+
+		select {
+		case result := <-done:
+			s.finish(entries, result)
+		case <-tick.C:
+		case <-report.C:
+			stats := s.Stats(true)
+			s.logger.Printf(
+				"message=Scheduler stats checks=%d lag_avg=%v lag_max=%v queued=%d busy=%d workers=%d",
+				stats.Checks, stats.LagAvg(), stats.LagMax, stats.Queued, stats.Busy, stats.Workers,
+			)
+		}
+	}
+}
+
+// Stats returns the stats since the last reset
+func (s *Scheduler) Stats(reset bool) SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Workers = s.workers
+	if reset {
+		s.stats.Checks = 0
+		s.stats.LagSum = 0
+		s.stats.LagMax = 0
+	}
+	return stats
+}
+
+// refresh picks up new, changed and deleted sites. A new site that was
+// checked before is next due one interval after its last ping, one that never
+// was is due within a few seconds so it doesn't sit unchecked.
+func (s *Scheduler) refresh(entries map[int64]*scheduled, now time.Time) map[int64]*scheduled {
+	next := map[int64]*scheduled{}
+	for _, site := range s.sites() {
+		entry, ok := entries[site.Id]
+		if !ok {
+			entry = &scheduled{due: now.Add(s.jitter(schedulerFirstCheck))}
+			if site.LastCheckedAt.Valid {
+				due := time.Unix(site.LastCheckedAt.Int64, 0).Add(site.Interval())
+				if due.Before(now) {
+					// overdue after a restart, spread the catch up a little
+					due = now.Add(s.jitter(site.Interval() / 10))
+				}
+				entry.due = due
+			}
+		}
+		entry.site = site
+		next[site.Id] = entry
+	}
+	return next
+}
+
+// dispatch hands due checks to idle workers, oldest first, and counts the
+// ones left waiting
+func (s *Scheduler) dispatch(entries map[int64]*scheduled, jobs chan<- job, now time.Time) {
+	var due []*scheduled
+	for _, entry := range entries {
+		if !entry.running && !now.Before(entry.due) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].due.Before(due[j].due)
+	})
+
+	queued := len(due)
+	for _, entry := range due {
+		select {
+		case jobs <- job{site: entry.site, due: entry.due}:
+			entry.running = true
+			queued--
+		default:
+		}
+	}
+
+	s.mu.Lock()
+	s.stats.Queued = queued
+	s.mu.Unlock()
+}
+
+// finish schedules the next check one interval after the previous due time
+// so checks don't drift, give or take a little jitter. A retry is scheduled
+// exactly, it confirms a failure and shouldn't wait.
+func (s *Scheduler) finish(entries map[int64]*scheduled, result result) {
+	entry, ok := entries[result.id]
+	if !ok {
+		return
+	}
+	entry.running = false
+	if result.retry > 0 {
+		entry.due = time.Now().Add(result.retry)
+		return
+	}
+	interval := entry.site.Interval()
+	spread := interval / 20
+	entry.due = entry.due.Add(interval - spread + s.jitter(2*spread))
+	if now := time.Now(); entry.due.Before(now) {
+		entry.due = now.Add(s.jitter(spread))
+	}
+}
+
+func (s *Scheduler) work(jobs <-chan job, done chan<- result) {
+	for job := range jobs {
+		lag := time.Since(job.due)
+		s.mu.Lock()
+		s.stats.Checks++
+		s.stats.Busy++
+		s.stats.LagSum += lag
+		if lag > s.stats.LagMax {
+			s.stats.LagMax = lag
+		}
+		s.mu.Unlock()
+
+		retry := s.check(job.site)
+
+		s.mu.Lock()
+		s.stats.Busy--
+		s.mu.Unlock()
+		done <- result{id: job.site.Id, retry: retry}
+	}
+}
+
+// jitter returns a random duration in [0, d)
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(s.rnd.Int63n(int64(d)))
+}
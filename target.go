@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Sites can't point checks at loopback, private or link local addresses
+// unless ALLOW_PRIVATE_TARGETS is set, so the worker can't be used to reach
+// the network it runs in. The form rejects such urls up front and the
+// worker's dialer refuses them when a name resolves to one later.
+
+const targetLookupTimeout = 2 * time.Second
+
+var errPrivateTarget = errors.New("is a private address, checks can only reach public hosts")
+
+// schemePattern matches a url that starts with a scheme, a :// later on, like
+// in a query, doesn't count
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// httpUrl normalizes a url to check: https:// is added when there is no
+// scheme, the host is lowercased and a bare host gets a trailing slash so
+// https://example.com and https://example.com/ are the same site
+func httpUrl(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !schemePattern.MatchString(raw) {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("invalid url, expected something like https://example.com/health")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("urls start with http:// or https://, use a TCP or DNS check for anything else")
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("urls need a host, like https://example.com")
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// publicHost returns an error when host is, or resolves to, an address
+// checks can't reach. A name that doesn't resolve yet is left to the checks.
+func publicHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%s %s", host, errPrivateTarget)
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), targetLookupTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if privateIP(ip) {
+			return fmt.Errorf("%s %s", host, errPrivateTarget)
+		}
+	}
+	return nil
+}
+
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// dialer connects checks, refusing private addresses unless they're allowed.
+// The address is checked once resolved so a name can't point elsewhere
+// after the form accepted it.
+func (this Worker) dialer(timeout time.Duration) *net.Dialer {
+	return targetDialer(this.config, timeout)
+}
+
+// targetDialer is the worker's dialer for what has no worker, like the
+// outbox posting to webhooks
+func targetDialer(config Config, timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if config.AllowPrivateTargets {
+		return dialer
+	}
+	dialer.Control = func(network string, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+			return fmt.Errorf("%s %s", host, errPrivateTarget)
+		}
+		return nil
+	}
+	return dialer
+}
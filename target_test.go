@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHttpUrl(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{"example.com", "https://example.com/", false},
+		{"  example.com/health  ", "https://example.com/health", false},
+		{"http://example.com", "http://example.com/", false},
+		{"HTTPS://Example.COM/Path", "https://example.com/Path", false},
+		{"https://example.com/#top", "https://example.com/", false},
+		{"example.com:8443", "https://example.com:8443/", false},
+		// a :// later on isn't the scheme
+		{"example.com/login?next=https://x.com", "https://example.com/login?next=https://x.com", false},
+		{"ftp://example.com", "", true},
+		{"tcp://example.com:25", "", true},
+		{"https://", "", true},
+		{"https:///path", "", true},
+		{"https://exa mple.com", "", true},
+	}
+	for _, test := range tests {
+		out, err := httpUrl(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("httpUrl(%q) error = %v, want error %v", test.in, err, test.err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("httpUrl(%q) = %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"93.184.216.34", false},
+		{"::ffff:93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+	for _, test := range tests {
+		if private := privateIP(net.ParseIP(test.ip)); private != test.private {
+			t.Errorf("privateIP(%s) = %v, want %v", test.ip, private, test.private)
+		}
+	}
+}
+
+func TestPublicHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		public bool
+	}{
+		{"localhost", false},
+		{"LOCALHOST.", false},
+		{"app.localhost", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"192.168.0.10", false},
+		{"169.254.169.254", false},
+		{"::ffff:127.0.0.1", false},
+		{"93.184.216.34", true},
+	}
+	for _, test := range tests {
+		if err := publicHost(test.host); (err == nil) != test.public {
+			t.Errorf("publicHost(%q) = %v, want public %v", test.host, err, test.public)
+		}
+	}
+}
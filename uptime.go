@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Uptime is the percentage of known time a site was up over rolling windows.
+// A ping's result is assumed to hold until the next ping but for no longer
+// than two of the site's check intervals, time not covered by any ping is
+// unknown and does not count either way, as is the time after a failure that
+// is still pending confirmation, time in maintenance and time paused. A
+// window without known time is not Valid.
+type Uptime struct {
+	Day     sql.NullFloat64
+	Week    sql.NullFloat64
+	Month   sql.NullFloat64
+	Quarter sql.NullFloat64
+}
+
+const day = 24 * 60 * 60
+
+// Uptimes returns the uptime of each of the user's sites keyed by site id
+func (m *Model) Uptimes(userId int64) map[int64]Uptime {
+	now := time.Now().Unix()
+	// go-sqlite3 binds $n parameters in order of first appearance, keep the
+	// numbering sequential when reusing them
+	rows, err := m.db.Query(
+		`
+		with spans as (
+			select
+				pings.site_id,
+				pings.up,
+				not pings.pending and not pings.maintenance and not pings.paused as counted,
+				pings.checked_at as started_at,
+				min(
+					coalesce(lead(pings.checked_at) over (partition by pings.site_id order by pings.checked_at), $1),
+					pings.checked_at + sites.check_interval * 2,
+					$1
+				) as ended_at
+			from pings
+			join sites on sites.id = pings.site_id
+			where sites.user_id = $2
+			and pings.checked_at >= $1 - $3 - $4 * 2
+		)
+		select
+			site_id,
+			sum(counted * up * max(0, ended_at - max(started_at, $1 - $5))), sum(counted * max(0, ended_at - max(started_at, $1 - $5))),
+			sum(counted * up * max(0, ended_at - max(started_at, $1 - $6))), sum(counted * max(0, ended_at - max(started_at, $1 - $6))),
+			sum(counted * up * max(0, ended_at - max(started_at, $1 - $7))), sum(counted * max(0, ended_at - max(started_at, $1 - $7))),
+			sum(counted * up * max(0, ended_at - max(started_at, $1 - $3))), sum(counted * max(0, ended_at - max(started_at, $1 - $3)))
+		from spans
+		group by site_id
+		`,
+		now, userId, 90*day, MaxHeartbeatInterval, day, 7*day, 30*day,
+	)
+	haltOn(err)
+	defer rows.Close()
+	uptimes := map[int64]Uptime{}
+	for rows.Next() {
+		var siteId int64
+		var up, known [4]int64
+		err = rows.Scan(
+			&siteId,
+			&up[0], &known[0],
+			&up[1], &known[1],
+			&up[2], &known[2],
+			&up[3], &known[3],
+		)
+		haltOn(err)
+		uptimes[siteId] = Uptime{
+			Day:     percentage(up[0], known[0]),
+			Week:    percentage(up[1], known[1]),
+			Month:   percentage(up[2], known[2]),
+			Quarter: percentage(up[3], known[3]),
+		}
+	}
+	haltOn(rows.Err())
+	return uptimes
+}
+
+func percentage(part int64, whole int64) sql.NullFloat64 {
+	if whole == 0 {
+		return sql.NullFloat64{Valid: false}
+	}
+	return sql.NullFloat64{Float64: float64(part) / float64(whole) * 100, Valid: true}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const KindWebhook = "webhook"
+
+const webhookTimeout = 10 * time.Second
+
+func init() {
+	RegisterNotifier(KindWebhook, webhookNotifier{})
+}
+
+type webhookNotifier struct{}
+
+func (webhookNotifier) Label() string {
+	return "Webhook"
+}
+
+func (webhookNotifier) Configure(config Config, channel *Channel, url string, key string) string {
+	channel.Url = url
+	channel.Secret = randomHex(32)
+	return webhookUrlError(config, url)
+}
+
+func (webhookNotifier) Format(config Config, channel Channel, alert Alert) (Message, error) {
+	if alert.Event == AlertCert {
+		return Message{}, errSkipAlert
+	}
+	payload, err := webhookPayload(alert)
+	return Message{Target: channel.Url, Subject: alert.Event, Payload: string(payload)}, err
+}
+
+func (webhookNotifier) Send(config Config, channel Channel, delivery Delivery) (int, error) {
+	return sendWebhook(config, channel, delivery)
+}
+
+// WebhookPayload is the JSON body posted to webhook channels when a site
+// changes status, certificate warnings only go to chat channels, e.g.
+//
+//	{
+//	  "event": "site.down",
+//	  "site": {"id": 1, "name": "Example", "url": "https://example.com"},
+//	  "old_status": "up",
+//	  "new_status": "down",
+//	  "status_code": 503,
+//	  "error": "HTTP 503 Service Unavailable, expected 200-399",
+//	  "error_kind": "http",
+//	  "incident": {"id": 7, "started_at": 1700000000, "resolved_at": null},
+//	  "acknowledge_url": "https://example.com/acknowledge?token=...",
+//	  "checked_at": 1700000000,
+//	  "created_at": 1700000001
+//	}
+//
+// event is "site.down", "site.up", "site.flapping" when a site's alerts are
+// paused because it keeps changing status, "site.settled" when they resume,
+// or "test" from the channels page. flaps counts the status changes of a
+// flapping site and is left out otherwise. old_status is null on a site's
+// first check, status_code is 0 when there was no http response and error,
+// error_kind, resolved_at and acknowledge_url are null when there are none.
+// Timestamps are unix seconds. The body is signed with the channel's secret,
+// the X-Uptime-Signature-256 header is "sha256=" and the hex HMAC-SHA256 of
+// the body.
+type WebhookPayload struct {
+	Event      string          `json:"event"`
+	Site       WebhookSite     `json:"site"`
+	OldStatus  *string         `json:"old_status"`
+	NewStatus  string          `json:"new_status"`
+	StatusCode int             `json:"status_code"`
+	Error      *string         `json:"error"`
+	ErrorKind  *string         `json:"error_kind"`
+	Incident   WebhookIncident `json:"incident"`
+	AckUrl     *string         `json:"acknowledge_url"`
+	Flaps      int             `json:"flaps,omitempty"`
+	CheckedAt  int64           `json:"checked_at"`
+	CreatedAt  int64           `json:"created_at"`
+}
+
+type WebhookSite struct {
+	Id   int64   `json:"id"`
+	Name *string `json:"name"`
+	Url  string  `json:"url"`
+}
+
+type WebhookIncident struct {
+	Id         int64  `json:"id"`
+	StartedAt  int64  `json:"started_at"`
+	ResolvedAt *int64 `json:"resolved_at"`
+}
+
+func webhookPayload(alert Alert) ([]byte, error) {
+	site, ping, transition, incident := alert.Site, alert.Ping, alert.Transition, alert.Incident
+	payload := WebhookPayload{
+		Event: alert.Event,
+		Site: WebhookSite{
+			Id:   site.Id,
+			Name: nullString(site.Name.String, site.Name.Valid),
+			Url:  site.Url,
+		},
+		OldStatus:  nullString(transition.FromStatus.String, transition.FromStatus.Valid),
+		NewStatus:  transition.ToStatus,
+		StatusCode: ping.StatusCode,
+		Incident: WebhookIncident{
+			Id:        incident.Id,
+			StartedAt: incident.StartedAt,
+		},
+		AckUrl:    nullString(alert.AckUrl, alert.AckUrl != ""),
+		Flaps:     alert.Flaps,
+		CheckedAt: ping.CheckedAt,
+		CreatedAt: time.Now().Unix(),
+	}
+	if ping.ErrorKind.Valid {
+		payload.Error = nullString(ping.Reason(), true)
+		payload.ErrorKind = &ping.ErrorKind.String
+	}
+	if incident.ResolvedAt.Valid {
+		payload.Incident.ResolvedAt = &incident.ResolvedAt.Int64
+	}
+	return json.Marshal(payload)
+}
+
+func nullString(s string, valid bool) *string {
+	if !valid {
+		return nil
+	}
+	return &s
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendWebhook(config Config, channel Channel, delivery Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+	return postJson(targetDialer(config, webhookTimeout), channel.Url, body, map[string]string{
+		"X-Uptime-Event":         delivery.Subject,
+		"X-Uptime-Delivery":      strconv.FormatInt(delivery.Id, 10),
+		"X-Uptime-Signature-256": sign(channel.Secret, body),
+	})
+}
+
+// postJson posts a payload through dialer and returns the response code, any
+// status outside 2xx is an error so the delivery is retried. Redirects are
+// dialed the same way.
+func postJson(dialer *net.Dialer, url string, body []byte, headers map[string]string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	client := &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyFromEnvironment,
+			DialContext:       dialer.DialContext,
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxBodyRead))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
@@ -1,53 +1,224 @@
 package main
 
 import (
-	"net/http"
-	"sync"
+	"database/sql"
 	"time"
 )
 
 type Worker struct {
 	logger Logger
 	model  Model
+	config Config
 }
 
-func NewWorker(logger Logger, model Model) Worker {
+func NewWorker(logger Logger, model Model, config Config) Worker {
 	return Worker{
 		logger: logger,
 		model:  model,
+		config: config,
 	}
 }
 
+// Work checks every site on its own interval with a bounded pool of workers
 func (this Worker) Work() {
-	go func() {
-		for {
-			this.PingAllSites()
-			time.Sleep(1 * time.Minute)
+	scheduler := NewScheduler(this.logger, this.config.Workers, this.sites, func(site Site) time.Duration {
+		if site.CheckType == CheckHeartbeat {
+			return this.checkHeartbeat(site)
 		}
-	}()
+		return this.record(site, this.check(site))
+	})
+	go scheduler.Run()
+	go this.escalations()
 }
 
-func (this Worker) PingAllSites() {
-	sites := this.model.AllSites()
-	length := len(sites)
+// record stores every ping and moves the site to the ping's status. A
+// failure is only acted on once the site's ConfirmFailures is reached, until
+// then record returns how soon to retry. Pings during maintenance are only
+// stored.
+func (this Worker) record(site Site, ping Ping) time.Duration {
+	if this.inMaintenance(site) {
+		ping.Maintenance = true
+		_, err := this.model.CreatePing(ping)
+		if err != nil {
+			this.logger.Printf("message=Could not create ping site_id=%d error=%q", site.Id, err)
+		}
+		return 0
+	}
+	if !ping.Up {
+		attempt, err := this.model.NextAttempt(site.Id)
+		if err != nil {
+			this.logger.Printf("message=Could not count attempts site_id=%d error=%q", site.Id, err)
+		}
+		ping.Attempt = attempt
+		ping.Pending = attempt < site.ConfirmFailures
+	}
+	if ping.Cert != nil {
+		this.recordCert(site, *ping.Cert)
+	}
+	ping, err := this.model.CreatePing(ping)
+	if err != nil {
+		this.logger.Printf("message=Could not create ping site_id=%d error=%q", site.Id, err)
+		return 0
+	}
+	if ping.Pending {
+		this.logger.Printf("message=Retrying failed check site_id=%d attempt=%d of=%d", site.Id, ping.Attempt, site.ConfirmFailures)
+		return site.RetryDelayDuration()
+	}
+	transition, err := this.model.RecordStatus(ping)
+	if err != nil {
+		this.logger.Printf("message=Could not record status site_id=%d error=%q", site.Id, err)
+		return 0
+	}
+	if transition != nil {
+		this.transition(site, ping, *transition)
+	} else if site.FlappingSince.Valid {
+		this.settle(site, ping)
+	}
+	return 0
+}
 
-	var wg sync.WaitGroup
-	wg.Add(length)
+// transition opens an incident when a site goes down and resolves it when
+// the site recovers, a flapping site's incidents are opened without alerts
+func (this Worker) transition(site Site, ping Ping, transition Transition) {
+	this.logger.Printf("message=Site status changed site_id=%d from=%s to=%s", ping.SiteId, transition.FromStatus.String, transition.ToStatus)
 
-	for i := 0; i < length; i++ {
-		go func(i int) {
-			defer wg.Done()
-			site := sites[i]
-			res, err := http.Head(site.Url)
+	switch transition.ToStatus {
+	case StatusDown:
+		first, err := this.model.FirstFailure(ping)
+		if err != nil {
+			this.logger.Printf("message=Could not find first failure site_id=%d error=%q", ping.SiteId, err)
+			first = ping
+		}
+		incident, err := this.model.OpenIncident(first)
+		if err != nil {
+			this.logger.Printf("message=Could not open incident site_id=%d error=%q", ping.SiteId, err)
+			return
+		}
+		if incident == nil {
+			return
+		}
+		this.logger.Printf("message=Incident opened site_id=%d incident_id=%d reason=%q", ping.SiteId, incident.Id, incident.Reason())
+		if this.flapping(site, transition) {
+			err = this.model.SuppressIncident(incident.Id)
 			if err != nil {
-				this.model.CreatePing(site.Id, 500)
-				//this.logger.Printf("%v", err.Error())
-				return
+				this.logger.Printf("message=Could not suppress incident incident_id=%d error=%q", incident.Id, err)
 			}
-			//this.logger.Printf("%s %d", res.Status, res.StatusCode)
-			this.model.CreatePing(site.Id, res.StatusCode)
-		}(i)
+			return
+		}
+		this.alert(site, ping, transition, *incident)
+	case StatusUp:
+		incident, err := this.model.ResolveIncident(ping)
+		if err != nil {
+			this.logger.Printf("message=Could not resolve incident site_id=%d error=%q", ping.SiteId, err)
+			return
+		}
+		this.flapping(site, transition)
+		if incident == nil {
+			return
+		}
+		this.logger.Printf("message=Incident resolved site_id=%d incident_id=%d duration=%v", ping.SiteId, incident.Id, incident.Duration())
+		// recoveries of incidents that were alerted still go out, so a
+		// page opened before the site started flapping gets resolved
+		if incident.Alerted {
+			this.alert(site, ping, transition, *incident)
+		}
 	}
+}
 
-	wg.Wait()
+// alert queues an incident opening or resolving for the user's email and
+// each channel routed to the site, or the site's escalation policy. The
+// outbox sends them.
+func (this Worker) alert(site Site, ping Ping, transition Transition, incident Incident) {
+	alert := Alert{
+		Event:      "site." + transition.ToStatus,
+		Site:       site,
+		Ping:       ping,
+		Transition: transition,
+		Incident:   incident,
+	}
+	if alert.Event == AlertDown {
+		alert.AckUrl = this.ackUrl(incident)
+	}
+	this.alertEmail(alert)
+	if site.EscalationPolicyId.Valid {
+		this.alertEscalation(alert)
+		return
+	}
+	this.alertChannels(alert)
+}
+
+// alertEmail sends to the profile email, when there is a mail server
+func (this Worker) alertEmail(alert Alert) {
+	if this.config.SmtpHost == "" {
+		return
+	}
+	email, err := this.model.UserEmail(alert.Site.UserId)
+	if err != nil {
+		this.logger.Printf("message=Could not find email site_id=%d error=%q", alert.Site.Id, err)
+		return
+	}
+	if !email.Valid {
+		return
+	}
+	err = this.notify(Channel{UserId: alert.Site.UserId, Kind: KindEmail, Url: email.String}, alert)
+	if err != nil {
+		this.logger.Printf("message=Could not queue email site_id=%d error=%q", alert.Site.Id, err)
+	}
+}
+
+// alertChannels queues an alert for each channel routed to the site
+func (this Worker) alertChannels(alert Alert) {
+	channels, err := this.model.SiteChannels(alert.Site)
+	if err != nil {
+		this.logger.Printf("message=Could not find channels site_id=%d error=%q", alert.Site.Id, err)
+		return
+	}
+	for _, channel := range channels {
+		err = this.notify(channel, alert)
+		if err != nil {
+			this.logger.Printf("message=Could not queue alert site_id=%d channel_id=%d error=%q", alert.Site.Id, channel.Id, err)
+		}
+	}
+}
+
+// notify formats an alert for a channel and queues it
+func (this Worker) notify(channel Channel, alert Alert) error {
+	notifier, err := findNotifier(channel.Kind)
+	if err != nil {
+		return err
+	}
+	message, err := notifier.Format(this.config, channel, alert)
+	if err == errSkipAlert {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = this.model.QueueDelivery(Delivery{
+		UserId:    channel.UserId,
+		SiteId:    sql.NullInt64{Int64: alert.Site.Id, Valid: alert.Site.Id != 0},
+		ChannelId: sql.NullInt64{Int64: channel.Id, Valid: channel.Id != 0},
+		Channel:   channel.Kind,
+		Target:    message.Target,
+		Subject:   message.Subject,
+		Payload:   message.Payload,
+	})
+	return err
+}
+
+// recordCert stores the site's certificate and raises a warning when it
+// crosses one of the configured thresholds
+func (this Worker) recordCert(site Site, cert Cert) {
+	site.CertExpiresAt = sql.NullInt64{Int64: cert.ExpiresAt, Valid: true}
+	site.CertIssuer = nullify(cert.Issuer)
+	level := certWarnLevel(site.CertDaysLeft(), this.config.CertWarnDays)
+	previous, err := this.model.RecordCert(site.Id, cert, level)
+	if err != nil {
+		this.logger.Printf("message=Could not record certificate site_id=%d error=%q", site.Id, err)
+		return
+	}
+	if level.Valid && (!previous.Valid || level.Int64 < previous.Int64) {
+		this.logger.Printf("message=Certificate expiring site_id=%d days_left=%d threshold=%d", site.Id, site.CertDaysLeft(), level.Int64)
+		this.alertChannels(Alert{Event: AlertCert, Site: site})
+	}
 }